@@ -0,0 +1,101 @@
+package documents
+
+import (
+	"jotno-server/money"
+	"time"
+)
+
+const (
+	KindInvoice = "invoice"
+	KindReceipt = "receipt"
+)
+
+// BillDocument holds everything printed on an invoice or receipt.
+type BillDocument struct {
+	Kind            string
+	Number          string
+	Language        string
+	IssuedAt        time.Time
+	CustomerName    string
+	CustomerAddress string
+	CustomerPhone   string
+	SpecialistName  string
+	SpecialistPhone string
+	JobType         string
+	Frequency       string
+	PeriodStart     time.Time
+	PeriodEnd       time.Time
+	Amount          money.Money
+	PlatformFee     money.Money
+	PaidAt          *time.Time
+	PaymentMethod   string
+}
+
+// RenderBill lays out an invoice or receipt as a one page PDF.
+func RenderBill(doc BillDocument) ([]byte, error) {
+	lang := doc.Language
+	p := newPage()
+
+	p.text(50, 780, 22, true, label(lang, doc.Kind))
+	p.text(470, 782, 18, true, "Jotno")
+	p.text(50, 752, 10, false, label(lang, "number")+": "+doc.Number)
+	p.text(50, 737, 10, false, label(lang, "issued")+": "+formatDate(doc.IssuedAt))
+	p.line(50, 722, 545, 722)
+
+	p.text(50, 700, 11, true, label(lang, "billedTo"))
+	p.text(50, 684, 10, false, doc.CustomerName)
+	p.text(50, 670, 10, false, doc.CustomerAddress)
+	p.text(50, 656, 10, false, doc.CustomerPhone)
+	p.text(310, 700, 11, true, label(lang, "specialist"))
+	p.text(310, 684, 10, false, doc.SpecialistName)
+	p.text(310, 670, 10, false, doc.SpecialistPhone)
+	p.line(50, 636, 545, 636)
+
+	p.text(50, 612, 11, true, label(lang, "service"))
+	p.text(230, 612, 11, true, label(lang, "period"))
+	p.text(430, 612, 11, true, label(lang, "amount"))
+	p.line(50, 602, 545, 602)
+
+	period := "-"
+	if !doc.PeriodStart.IsZero() {
+		// Periods end at the start of the next one, so the last day is the
+		// day before.
+		period = formatDate(doc.PeriodStart) + " - " + formatDate(doc.PeriodEnd.AddDate(0, 0, -1))
+	}
+	p.text(50, 584, 10, false, label(lang, doc.JobType)+" ("+label(lang, doc.Frequency)+")")
+	p.text(230, 584, 10, false, period)
+	p.text(430, 584, 10, false, formatAmount(doc.Amount))
+
+	y := 560.0
+	if doc.PlatformFee.IsPositive() {
+		earnings, err := doc.Amount.Sub(doc.PlatformFee)
+		if err != nil {
+			return nil, err
+		}
+		p.text(230, y, 10, false, label(lang, "platformFee"))
+		p.text(430, y, 10, false, formatAmount(doc.PlatformFee))
+		y -= 16
+		p.text(230, y, 10, false, label(lang, "earnings"))
+		p.text(430, y, 10, false, formatAmount(earnings))
+		y -= 16
+	}
+	p.line(230, y, 545, y)
+	y -= 18
+	p.text(230, y, 12, true, label(lang, "total"))
+	p.text(430, y, 12, true, formatAmount(doc.Amount))
+
+	y -= 40
+	status := label(lang, "unpaid")
+	if doc.PaidAt != nil {
+		status = label(lang, "paid")
+	}
+	p.text(50, y, 10, true, label(lang, "status")+": "+status)
+	if doc.PaidAt != nil {
+		p.text(50, y-16, 10, false, label(lang, "paidOn")+": "+formatDate(*doc.PaidAt))
+		p.text(50, y-32, 10, false, label(lang, "paymentMethod")+": "+label(lang, doc.PaymentMethod))
+	}
+
+	p.line(50, 100, 545, 100)
+	p.text(50, 80, 10, false, label(lang, "thanks"))
+	return p.render()
+}
@@ -0,0 +1,84 @@
+package documents
+
+import (
+	"strings"
+	"time"
+)
+
+// Calendar is an iCalendar feed of booked visits.
+type Calendar struct {
+	Name   string
+	Events []CalendarEvent
+}
+
+// CalendarEvent is one visit, shown as the service and who it is with.
+type CalendarEvent struct {
+	UID             string
+	Start           time.Time
+	End             time.Time
+	JobType         string
+	CounterpartName string
+	Address         string
+	URL             string
+}
+
+// RenderCalendar writes the calendar as an RFC 5545 .ics file. Times are
+// written in UTC so calendar apps need no timezone definitions.
+func RenderCalendar(calendar Calendar, now time.Time) []byte {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:-//Jotno//Bookings//EN")
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	writeLine(&b, "X-WR-CALNAME:"+escapeText(calendar.Name))
+	for _, event := range calendar.Events {
+		summary := label(LanguageEnglish, event.JobType)
+		if event.CounterpartName != "" {
+			summary += " with " + event.CounterpartName
+		}
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+event.UID)
+		writeLine(&b, "DTSTAMP:"+icsTime(now))
+		writeLine(&b, "DTSTART:"+icsTime(event.Start))
+		writeLine(&b, "DTEND:"+icsTime(event.End))
+		writeLine(&b, "SUMMARY:"+escapeText(summary))
+		if event.Address != "" {
+			writeLine(&b, "LOCATION:"+escapeText(event.Address))
+		}
+		if event.URL != "" {
+			writeLine(&b, "URL:"+event.URL)
+		}
+		writeLine(&b, "END:VEVENT")
+	}
+	writeLine(&b, "END:VCALENDAR")
+	return []byte(b.String())
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escapeText escapes the characters RFC 5545 reserves in text values.
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeLine ends a content line with CRLF, folding it so no line is longer
+// than 75 octets. Folds never split a UTF-8 sequence.
+func writeLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation counts towards its length.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
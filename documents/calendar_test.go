@@ -0,0 +1,90 @@
+package documents
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "SUMMARY:Cook", "SUMMARY:Cook\r\n"},
+		{"exactly 75 octets", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{
+			name: "folded",
+			line: strings.Repeat("a", 160),
+			want: strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n " + strings.Repeat("a", 11) + "\r\n",
+		},
+		{
+			// "ক" is three octets and would straddle the 75th.
+			name: "multi-byte character at the fold",
+			line: strings.Repeat("a", 74) + "কখ",
+			want: strings.Repeat("a", 74) + "\r\n কখ\r\n",
+		},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		writeLine(&b, test.line)
+		if b.String() != test.want {
+			t.Errorf("%s: writeLine wrote %q; want %q", test.name, b.String(), test.want)
+		}
+	}
+}
+
+func TestWriteLineLimits(t *testing.T) {
+	line := "LOCATION:" + strings.Repeat("বাড়ি ১২, রোড ৫, ধানমন্ডি ", 10)
+	var b strings.Builder
+	writeLine(&b, line)
+
+	folded := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	var unfolded strings.Builder
+	for i, part := range folded {
+		if len(part) > 75 {
+			t.Errorf("line %d is %d octets long", i, len(part))
+		}
+		if i > 0 {
+			if !strings.HasPrefix(part, " ") {
+				t.Fatalf("continuation %d does not start with a space: %q", i, part)
+			}
+			part = part[1:]
+		}
+		unfolded.WriteString(part)
+	}
+	if unfolded.String() != line {
+		t.Errorf("unfolding gave %q; want %q", unfolded.String(), line)
+	}
+}
+
+func TestRenderCalendar(t *testing.T) {
+	dhaka := time.FixedZone("Asia/Dhaka", 6*60*60)
+	calendar := Calendar{
+		Name: "Jotno, bookings",
+		Events: []CalendarEvent{{
+			UID:             "booking-7-20260105@jotno",
+			Start:           time.Date(2026, time.January, 5, 9, 0, 0, 0, dhaka),
+			End:             time.Date(2026, time.January, 5, 17, 0, 0, 0, dhaka),
+			CounterpartName: "Rahima",
+			Address:         "House 12; Road 5",
+		}},
+	}
+	ics := string(RenderCalendar(calendar, time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)))
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Jotno\\, bookings\r\n",
+		"UID:booking-7-20260105@jotno\r\n",
+		"DTSTAMP:20260101T000000Z\r\n",
+		"DTSTART:20260105T030000Z\r\n",
+		"DTEND:20260105T110000Z\r\n",
+		"LOCATION:House 12\\; Road 5\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("calendar is missing %q:\n%s", line, ics)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package documents
+
+import (
+	"jotno-server/money"
+	"time"
+)
+
+// Documents are rendered in English. Bangla is recognised so it can be
+// turned down plainly: its conjuncts need shaping the renderer does not do.
+const (
+	LanguageEnglish = "en"
+	LanguageBangla  = "bn"
+)
+
+var labels = map[string]map[string]string{
+	LanguageEnglish: {
+		"invoice":       "INVOICE",
+		"receipt":       "RECEIPT",
+		"number":        "Number",
+		"issued":        "Issued",
+		"billedTo":      "Billed to",
+		"specialist":    "Specialist",
+		"service":       "Service",
+		"period":        "Period",
+		"amount":        "Amount",
+		"platformFee":   "Platform fee (included)",
+		"earnings":      "Specialist earnings",
+		"total":         "Total",
+		"status":        "Status",
+		"paid":          "Paid",
+		"unpaid":        "Unpaid",
+		"paidOn":        "Paid on",
+		"paymentMethod": "Payment method",
+		"monthly":       "monthly",
+		"daily":         "daily",
+		"petCare":       "Pet care",
+		"elderlyCare":   "Elderly care",
+		"babySitting":   "Babysitting",
+		"houseKeeping":  "Housekeeping",
+		"teaching":      "Teaching",
+		"cash":          "Cash",
+		"card":          "Card",
+		"bkash":         "bKash",
+		"nagad":         "Nagad",
+		"rocket":        "Rocket",
+		"thanks":        "Thank you for using Jotno.",
+	},
+}
+
+// label looks up a label, falling back to English and then to the key itself.
+func label(language string, key string) string {
+	if text, ok := labels[language][key]; ok {
+		return text
+	}
+	if text, ok := labels[LanguageEnglish][key]; ok {
+		return text
+	}
+	return key
+}
+
+func formatDate(date time.Time) string {
+	return date.Format("02 Jan 2006")
+}
+
+func formatAmount(amount money.Money) string {
+	return amount.String()
+}
@@ -0,0 +1,54 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// page draws onto a single A4 page, setting text in Helvetica.
+type page struct {
+	content bytes.Buffer
+}
+
+func newPage() *page {
+	return &page{}
+}
+
+func (p *page) text(x float64, y float64, size float64, bold bool, s string) {
+	if s == "" {
+		return
+	}
+	fontName := "/F1"
+	if bold {
+		fontName = "/F2"
+	}
+	fmt.Fprintf(&p.content, "BT %.2f %.2f Td %s %.1f Tf %s Tj ET\n", x, y, fontName, size, pdfString(s))
+}
+
+func (p *page) line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (p *page) render() ([]byte, error) {
+	file := &pdfFile{}
+	catalog := file.reserve()
+	pages := file.reserve()
+	pageObject := file.reserve()
+
+	regular := file.add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	bold := file.add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	fonts := "/F1 " + ref(regular) + " /F2 " + ref(bold)
+
+	content, err := file.addStream("", p.content.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	file.set(pageObject, fmt.Sprintf(
+		"<< /Type /Page /Parent %s /MediaBox [0 0 595 842] /Resources << /Font << %s >> >> /Contents %s >>",
+		ref(pages), fonts, ref(content),
+	))
+	file.set(pages, "<< /Type /Pages /Kids ["+ref(pageObject)+"] /Count 1 >>")
+	file.set(catalog, "<< /Type /Catalog /Pages "+ref(pages)+" >>")
+	return file.bytes(catalog), nil
+}
@@ -0,0 +1,98 @@
+package documents
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strconv"
+)
+
+// pdfFile collects numbered objects and writes them out with a cross-reference
+// table. Object numbers are handed out first so objects can refer to ones that
+// are filled in later.
+type pdfFile struct {
+	objects [][]byte
+}
+
+func (f *pdfFile) reserve() int {
+	f.objects = append(f.objects, nil)
+	return len(f.objects)
+}
+
+func (f *pdfFile) set(number int, body string) {
+	f.objects[number-1] = []byte(body)
+}
+
+func (f *pdfFile) add(body string) int {
+	number := f.reserve()
+	f.set(number, body)
+	return number
+}
+
+// addStream stores data as a Flate-compressed stream. extra holds any further
+// dictionary entries.
+func (f *pdfFile) addStream(extra string, data []byte) (int, error) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, err := writer.Write(data)
+	if err != nil {
+		return 0, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "<< /Length %d /Filter /FlateDecode %s>>\nstream\n", compressed.Len(), extra)
+	body.Write(compressed.Bytes())
+	body.WriteString("\nendstream")
+
+	number := f.reserve()
+	f.objects[number-1] = body.Bytes()
+	return number, nil
+}
+
+func (f *pdfFile) bytes(root int) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(f.objects))
+	for i, object := range f.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(object)
+		out.WriteString("\nendobj\n")
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(f.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(f.objects)+1, root, xref)
+	return out.Bytes()
+}
+
+func ref(number int) string {
+	return strconv.Itoa(number) + " 0 R"
+}
+
+// pdfString escapes text for a literal string in a standard 14 font, which
+// only covers Latin-1.
+func pdfString(s string) string {
+	var out bytes.Buffer
+	out.WriteByte('(')
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		switch r {
+		case '(', ')', '\\':
+			out.WriteByte('\\')
+		}
+		out.WriteByte(byte(r))
+	}
+	out.WriteByte(')')
+	return out.String()
+}
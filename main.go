@@ -1,7 +1,8 @@
 package main
 
 import (
-	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/payments"
 	"jotno-server/routes"
 	"jotno-server/storage"
 	"jotno-server/utils"
@@ -21,9 +22,14 @@ func main() {
 	storage.InitializeDB()
 	storage.InitializeS3()
 	storage.InitializeRedis()
+	payments.InitializeProviders()
+	money.InitializeRates()
+	routes.StartJobPostFanOutWorker()
 
 	app := iris.Default()
-	app.Validator = validator.New()
+	validate := validator.New()
+	validate.RegisterValidation("currency", money.ValidateCurrency)
+	app.Validator = validate
 
 	// Reset token verifiers
 	resetTokenVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("EMAIL_TOKEN_SECRET")))
@@ -70,32 +76,55 @@ func main() {
 		user.Post("/forgotPassword", routes.ForgotPassword)
 		user.Post("/resetPassword", resetTokenVerifierMiddleware, routes.ResetPassword)
 
-		user.Get("/specialist/favorited", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetUserFavoritedSpecialists)
-		user.Patch("/updateUserInformation", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.UpdateUserInformation)
-		user.Patch("/specialist/favorited", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AlterUserFavorites)
-		user.Patch("/pushToken", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AlterPushToken)
-		user.Patch("/settings/notifications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AllowsNotifications)
+		user.Get("/specialist/favorited", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.GetUserFavoritedSpecialists)
+		user.Patch("/updateUserInformation", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.UpdateUserInformation)
+		user.Patch("/specialist/favorited", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.AlterUserFavorites)
+		user.Patch("/pushToken", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.AlterPushToken)
+		user.Patch("/settings/notifications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.AllowsNotifications)
 	}
 
 	specialist := app.Party("/jotno/api/specialist")
 	{
 		specialist.Post("/register", routes.RegisterSpecialist)
+		specialist.Post("/login", routes.LoginSpecialist)
+		specialist.Patch("/pushToken", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.AlterSpecialistPushToken)
+		specialist.Patch("/settings/notifications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.AllowsSpecialistNotifications)
 		// specialist.Get("/{specialistId}/user", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetSpecialistByID)
+		specialist.Get("/earnings", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.GetSpecialistEarnings)
 		specialist.Get("/getSpecialist", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetSpecialistByIDAndJobName)
+		specialist.Get("/availability", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetSpecialistAvailability)
+		specialist.Patch("/cancellationPolicy", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.SetCancellationPolicy)
 		specialist.Post("/search", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetSpecialistByBoundingBox)
 	}
 
 	jobPost := app.Party("/jotno/api/jobPost")
 	{
-		jobPost.Get("/getJobPosts", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetJobsPostsByUserID)
-		jobPost.Post("/createJobPosts", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CreateJobPosts)
-		jobPost.Delete("/deleteJobPost", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteJobPost)
+		jobPost.Get("/getJobPosts", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.GetJobsPostsByUserID)
+		jobPost.Post("/createJobPosts", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.CreateJobPosts)
+		jobPost.Patch("/updateJobPost", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.UpdateJobPost)
+		jobPost.Delete("/deleteJobPost", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.DeleteJobPost)
+		jobPost.Post("/apply", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.ApplyToJobPost)
+		jobPost.Get("/getApplications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.GetApplicationsByJobPostID)
+		jobPost.Get("/getSpecialistApplications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.GetApplicationsBySpecialistID)
+		jobPost.Patch("/updateApplicationStatus", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.UpdateApplicationStatus)
+		jobPost.Post("/hire", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.HireApplicant)
+		jobPost.Get("/board", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.GetJobBoard)
+		jobPost.Post("/dismiss", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.DismissJobPost)
 	}
 
-	// notification := app.Party("/jotno/api/notification")
-	// {
-	// 	notification.Post("/sendNotification", routes.SendNotification)
-	// }
+	savedSearch := app.Party("/jotno/api/savedSearch")
+	{
+		savedSearch.Get("/getSavedSearches", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.GetSavedSearches)
+		savedSearch.Post("/create", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.CreateSavedSearch)
+		savedSearch.Delete("/delete", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.DeleteSavedSearch)
+	}
+
+	notification := app.Party("/jotno/api/notification")
+	{
+		// notification.Post("/sendNotification", routes.SendNotification)
+		notification.Get("/getNotifications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetNotifications)
+		notification.Patch("/markRead", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.MarkNotificationsRead)
+	}
 
 	chat := app.Party("/jotno/api/chat")
 	{
@@ -103,44 +132,127 @@ func main() {
 		chat.Post("/open", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetChatByUserAndSpecialistID)
 		chat.Get("/getChat", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetChatByID)
 		chat.Get("/getChats", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetChatsByUserID)
+		chat.Get("/messages", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetChatMessages)
+		chat.Get("/live", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.ChatWebSocket)
+		chat.Get("/events", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.ChatEvents)
 	}
 
 	messages := app.Party("/jotno/api/messages")
 	{
 		messages.Post("/create", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CreateMessage)
+		messages.Patch("/edit", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.EditMessage)
+		messages.Delete("/delete", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteMessage)
 	}
 
 	booking := app.Party("/jotno/api/booking")
 	{
-		booking.Get("/getBookingByUser", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBookingByUserID)
-		booking.Post("/create", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CreateBooking)
+		booking.Get("/getBookings", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBookings)
+		booking.Get("/getBookingByUser", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.GetBookingByUserID)
+		booking.Post("/create", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.CreateBooking)
 		booking.Patch("/cancelBooking", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CancelBooking)
+		booking.Get("/cancellationQuote", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetCancellationQuote)
+		booking.Patch("/accept", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.AcceptBooking)
+		booking.Patch("/decline", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.DeclineBooking)
+		booking.Patch("/complete", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CompleteBooking)
+		booking.Get("/bills", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBookingBills)
+		booking.Get("/billDocument", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBillDocument)
 		booking.Get("/getPendingPaymentsByBookingID", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetPendingPaymentsByBookingID)
-		// booking.Patch("/updateBooking", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteJobPost)
-		// booking.Patch("/updatePayment", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteJobPost)
+		booking.Post("/changeRequest", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.RequestBookingChange)
+		booking.Get("/changeRequests", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBookingChanges)
+		booking.Patch("/changeRequest/accept", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AcceptBookingChange)
+		booking.Patch("/changeRequest/decline", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeclineBookingChange)
+		booking.Patch("/changeRequest/withdraw", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.WithdrawBookingChange)
+		booking.Patch("/markBillPaid", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.MarkBillPaid)
+		booking.Patch("/confirmBillReceived", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.ConfirmBillReceived)
+		booking.Post("/checkIn", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.CheckIn)
+		booking.Post("/checkOut", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.CheckOut)
+		booking.Get("/timesheet", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetTimesheet)
+		booking.Get("/occurrences", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetBookingOccurrences)
+		booking.Post("/dropOut", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.DropOutOfBooking)
+		booking.Get("/substitutions", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetSubstitutions)
+		booking.Get("/substitutes", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.GetSubstituteCandidates)
+		booking.Patch("/substitution/approve", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.ApproveSubstitute)
+		booking.Patch("/substitution/accept", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.AcceptSubstitution)
+		booking.Patch("/substitution/decline", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.SpecialistMiddleware, routes.DeclineSubstitution)
+	}
+
+	payment := app.Party("/jotno/api/payment")
+	{
+		payment.Post("/checkout", accessTokenVerifierMiddleware, utils.UserIDMiddleware, utils.UserMiddleware, routes.StartCheckout)
+		payment.Post("/webhook/{provider}", routes.PaymentWebhook)
+	}
+
+	calendar := app.Party("/jotno/api/calendar")
+	{
+		calendar.Get("/feed", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetCalendarFeed)
+		calendar.Post("/feed", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CreateCalendarFeed)
+		calendar.Delete("/feed", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.RevokeCalendarFeed)
+		calendar.Get("/{secret}", routes.ServeCalendarFeed)
+	}
+
+	dispute := app.Party("/jotno/api/dispute")
+	{
+		dispute.Post("/open", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.OpenDispute)
+		dispute.Get("/getDispute", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetDispute)
+		dispute.Post("/evidence", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AddDisputeEvidence)
+		dispute.Post("/message", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AddDisputeMessage)
+	}
+
+	admin := app.Party("/jotno/api/admin", utils.AdminMiddleware)
+	{
+		admin.Get("/jobRuns", routes.GetJobRuns)
+		admin.Post("/jobRuns/trigger", routes.TriggerJobRun)
+		admin.Get("/disputes", routes.GetDisputes)
+		admin.Post("/disputes/message", routes.AddAdminDisputeMessage)
+		admin.Post("/disputes/resolve", routes.ResolveDispute)
+		admin.Get("/commissionRules", routes.GetCommissionRules)
+		admin.Put("/commissionRules", routes.SetCommissionRule)
+		admin.Post("/payouts", routes.RecordPayout)
+		admin.Get("/flaggedSpecialists", routes.GetFlaggedSpecialists)
+		admin.Post("/flaggedSpecialists/clear", routes.ClearSpecialistFlag)
 	}
 
 	scheduler := tasks.New()
 	defer scheduler.Stop()
 
+	scheduler.Add(&tasks.Task{
+		Interval: time.Hour,
+		TaskFunc: routes.ScheduledTask(routes.JobBilling),
+	})
+
 	scheduler.Add(&tasks.Task{
 		Interval: (24 * time.Hour),
-		TaskFunc: func() error {
-			var activeBookings []models.Booking
-			activeBookingsExists:= storage.DB.Where("active = true AND frequency = 'monthly' AND overdue = false").Find(&activeBookings)
-			if activeBookingsExists.Error != nil {
-				return nil
-			}
-			for i := 0; i < len(activeBookings); i++ {
-				routes.CreateBill(
-					activeBookings[i].ID,
-					activeBookings[i].Amount,
-					activeBookings[i].Currency,
-					activeBookings[i].UserID,
-				) 
-			}
-			return nil
-		},
+		TaskFunc: routes.ScheduledTask(routes.JobBillReminders),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: time.Hour,
+		TaskFunc: routes.ScheduledTask(routes.JobExpireJobPosts),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: time.Hour,
+		TaskFunc: routes.ScheduledTask(routes.JobActivateBookings),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: (24 * time.Hour),
+		TaskFunc: routes.ScheduledTask(routes.JobAlertDigests),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: (24 * time.Hour),
+		TaskFunc: routes.ScheduledTask(routes.JobCashDisputes),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: time.Hour,
+		TaskFunc: routes.ScheduledTask(routes.JobBookingPauses),
+	})
+
+	scheduler.Add(&tasks.Task{
+		Interval: time.Hour,
+		TaskFunc: routes.ScheduledTask(routes.JobDuplicatePayments),
 	})
 
 	app.Listen(":4000")
@@ -1,13 +1,28 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"jotno-server/money"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Bill struct {
 	gorm.Model
-	BookingID uint   `json:"bookingID"`
-	Paid      bool   `json:"paid"`
-	Received  bool   `json:"received"`
-	Complete  bool   `json:"complete"`
-	Amount    int32  `json:"amount"`
-	Currency  string `json:"currency"`
+	BookingID      uint         `json:"bookingID" gorm:"uniqueIndex:idx_bill_booking_specialist_period"`
+	SpecialistID   uint         `json:"specialistID" gorm:"uniqueIndex:idx_bill_booking_specialist_period"`
+	Kind           string       `json:"kind"`
+	Paid           bool         `json:"paid"`
+	Received       bool         `json:"received"`
+	Complete       bool         `json:"complete"`
+	Amount         money.Money  `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	PlatformFee    money.Money  `json:"platformFee" gorm:"embedded;embeddedPrefix:platform_fee_"`
+	PeriodStart    time.Time    `json:"periodStart" gorm:"uniqueIndex:idx_bill_booking_specialist_period"`
+	PeriodEnd      time.Time    `json:"periodEnd"`
+	Prorated       bool         `json:"prorated"`
+	PaidAt         *time.Time   `json:"paidAt"`
+	ReceivedAt     *time.Time   `json:"receivedAt"`
+	RefundedAmount money.Money  `json:"refundedAmount" gorm:"embedded;embeddedPrefix:refunded_amount_"`
+	DisputeStatus  string       `json:"disputeStatus"`
+	Dispute        *BillDispute `json:"dispute,omitempty"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"jotno-server/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type BillDispute struct {
+	gorm.Model
+	BillID         uint              `json:"billID" gorm:"uniqueIndex"`
+	BookingID      uint              `json:"bookingID" gorm:"index"`
+	Status         string            `json:"status" gorm:"index"`
+	OpenedByID     uint              `json:"openedByID"`
+	OpenedByRole   string            `json:"openedByRole"`
+	ReasonCode     string            `json:"reasonCode"`
+	Reason         string            `json:"reason"`
+	Outcome        string            `json:"outcome"`
+	RefundAmount   money.Money       `json:"refundAmount" gorm:"embedded;embeddedPrefix:refund_amount_"`
+	ResolutionNote string            `json:"resolutionNote"`
+	ResolvedAt     *time.Time        `json:"resolvedAt"`
+	Evidence       []DisputeEvidence `json:"evidence" gorm:"foreignKey:DisputeID"`
+	Messages       []DisputeMessage  `json:"messages" gorm:"foreignKey:DisputeID"`
+}
@@ -1,19 +1,37 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"jotno-server/money"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Booking struct {
 	gorm.Model
-	UserID       uint   `json:"userID"`
-	SpecialistID uint   `json:"specialistID"`
-	JobType      string `json:"jobType"`
-	Active       bool   `json:"active"`
-	Status       string `json:"status"`
-	Frequency    string `json:"frequency"`
-	Amount       int32  `json:"amount"`
-	Currency     string `json:"currency"`
-	Overdue      bool   `json:"overdue"`
-	Bills        []Bill `json:"bills"`
-	StartDate    string `json:"startDate"`
-	EndDate      string `json:"endDate"`
+	UserID             uint        `json:"userID"`
+	SpecialistID       uint        `json:"specialistID"`
+	JobPostID          *uint       `json:"jobPostID"`
+	JobType            string      `json:"jobType"`
+	Active             bool        `json:"active"`
+	Status             string      `json:"status" gorm:"index"`
+	Frequency          string      `json:"frequency"`
+	Recurrence         string      `json:"recurrence"`
+	StartTime          string      `json:"startTime"`
+	EndTime            string      `json:"endTime"`
+	Timezone           string      `json:"timezone"`
+	Amount             money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Overdue            bool        `json:"overdue"`
+	Bills              []Bill      `json:"bills"`
+	StartDate          string      `json:"startDate"`
+	EndDate            string      `json:"endDate"`
+	RequestedAt        *time.Time  `json:"requestedAt"`
+	AcceptedAt         *time.Time  `json:"acceptedAt"`
+	DeclinedAt         *time.Time  `json:"declinedAt"`
+	ActivatedAt        *time.Time  `json:"activatedAt"`
+	PausedAt           *time.Time  `json:"pausedAt"`
+	CompletedAt        *time.Time  `json:"completedAt"`
+	CancelledAt        *time.Time  `json:"cancelledAt"`
+	CancellationReason string      `json:"cancellationReason"`
+	CancellationPolicy string      `json:"cancellationPolicy"`
 }
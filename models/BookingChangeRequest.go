@@ -0,0 +1,28 @@
+package models
+
+import (
+	"jotno-server/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type BookingChangeRequest struct {
+	gorm.Model
+	BookingID         uint        `json:"bookingID" gorm:"index"`
+	Kind              string      `json:"kind"`
+	Status            string      `json:"status" gorm:"index"`
+	RequestedByID     uint        `json:"requestedByID"`
+	RequestedByRole   string      `json:"requestedByRole"`
+	Reason            string      `json:"reason"`
+	StartDate         string      `json:"startDate"`
+	EndDate           string      `json:"endDate"`
+	PauseStart        *time.Time  `json:"pauseStart"`
+	PauseEnd          *time.Time  `json:"pauseEnd"`
+	Amount            money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Frequency         string      `json:"frequency"`
+	PreviousAmount    money.Money `json:"previousAmount" gorm:"embedded;embeddedPrefix:previous_amount_"`
+	PreviousFrequency string      `json:"previousFrequency"`
+	EffectiveFrom     *time.Time  `json:"effectiveFrom"`
+	RespondedAt       *time.Time  `json:"respondedAt"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "gorm.io/gorm"
+
+type BookingHistory struct {
+	gorm.Model
+	BookingID  uint   `json:"bookingID" gorm:"index"`
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+	ActorID    uint   `json:"actorID"`
+	ActorRole  string `json:"actorRole"`
+	Reason     string `json:"reason"`
+	BillID     *uint  `json:"billID"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CalendarFeed struct {
+	gorm.Model
+	OwnerID       uint       `json:"ownerID" gorm:"uniqueIndex:idx_calendar_feed_active_owner,where:revoked_at IS NULL"`
+	OwnerRole     string     `json:"ownerRole" gorm:"uniqueIndex:idx_calendar_feed_active_owner,where:revoked_at IS NULL"`
+	TokenHash     string     `json:"-" gorm:"uniqueIndex"`
+	LastFetchedAt *time.Time `json:"lastFetchedAt"`
+	RevokedAt     *time.Time `json:"revokedAt"`
+}
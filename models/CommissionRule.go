@@ -0,0 +1,11 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type CommissionRule struct {
+	gorm.Model
+	JobType string  `json:"jobType" gorm:"uniqueIndex"`
+	Percent float64 `json:"percent"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type DisputeEvidence struct {
+	gorm.Model
+	DisputeID    uint   `json:"disputeID" gorm:"index"`
+	UploaderID   uint   `json:"uploaderID"`
+	UploaderRole string `json:"uploaderRole"`
+	URL          string `json:"url"`
+	Description  string `json:"description"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type DisputeMessage struct {
+	gorm.Model
+	DisputeID  uint   `json:"disputeID" gorm:"index"`
+	SenderID   uint   `json:"senderID"`
+	SenderRole string `json:"senderRole"`
+	Text       string `json:"text"`
+}
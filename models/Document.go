@@ -0,0 +1,14 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type Document struct {
+	gorm.Model
+	BillID     uint   `json:"billID" gorm:"uniqueIndex:idx_document_bill_kind_language"`
+	Kind       string `json:"kind" gorm:"uniqueIndex:idx_document_bill_kind_language"`
+	Language   string `json:"language" gorm:"uniqueIndex:idx_document_bill_kind_language"`
+	Number     string `json:"number" gorm:"index"`
+	StorageKey string `json:"-"`
+}
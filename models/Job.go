@@ -7,7 +7,8 @@ import (
 
 type Job struct {
 	gorm.Model
-	JobName      string         `json:"jobName"`
-	SpecialistID uint           `json:"specialistID"`
-	Frequencies  datatypes.JSON `json:"frequencies"`
+	JobName            string         `json:"jobName"`
+	SpecialistID       uint           `json:"specialistID"`
+	Frequencies        datatypes.JSON `json:"frequencies"`
+	CancellationPolicy string         `json:"cancellationPolicy"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type JobAlert struct {
+	gorm.Model
+	SavedSearchID uint       `json:"savedSearchID" gorm:"uniqueIndex:idx_job_alert_search_post"`
+	JobPostID     uint       `json:"jobPostID" gorm:"uniqueIndex:idx_job_alert_search_post"`
+	SpecialistID  uint       `json:"specialistID" gorm:"index"`
+	Channel       string     `json:"channel"`
+	DeliveredAt   *time.Time `json:"deliveredAt"`
+}
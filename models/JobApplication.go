@@ -0,0 +1,16 @@
+package models
+
+import (
+	"jotno-server/money"
+
+	"gorm.io/gorm"
+)
+
+type JobApplication struct {
+	gorm.Model
+	JobPostID    uint        `json:"jobPostID" gorm:"uniqueIndex:idx_job_application_post_specialist"`
+	SpecialistID uint        `json:"specialistID" gorm:"uniqueIndex:idx_job_application_post_specialist"`
+	Message      string      `json:"message"`
+	Rate         money.Money `json:"rate" gorm:"embedded;embeddedPrefix:rate_"`
+	Status       string      `json:"status"`
+}
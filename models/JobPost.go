@@ -1,18 +1,24 @@
 package models
 
 import (
+	"jotno-server/money"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type JobPost struct {
 	gorm.Model
-	UserID        uint      `json:"userID"`
-	JobType       string    `json:"jobType"`
-	Title         string    `json:"title"`
-	Description   string    `json:"description"`
-	Wage          int       `json:"wage"`
-	WageCurrency  string    `json:"wageCurrency"`
-	WageFrequency string    `json:"wageFrequency"`
-	DateTime      string    `json:"dateTime"`
-	Comments      []Comment `json:"comments"`
+	UserID        uint        `json:"userID"`
+	JobType       string      `json:"jobType"`
+	Title         string      `json:"title"`
+	Description   string      `json:"description"`
+	Wage          money.Money `json:"wage" gorm:"embedded;embeddedPrefix:wage_"`
+	WageFrequency string      `json:"wageFrequency"`
+	DateTime      string      `json:"dateTime"`
+	Status        string      `json:"status" gorm:"index"`
+	ExpiresAt     time.Time   `json:"expiresAt" gorm:"index"`
+	Comments      []Comment   `json:"comments"`
+	// ApplicantCount is filled in by the listing endpoints and not stored.
+	ApplicantCount int64 `json:"applicantCount" gorm:"-"`
 }
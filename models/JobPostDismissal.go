@@ -0,0 +1,9 @@
+package models
+
+import "gorm.io/gorm"
+
+type JobPostDismissal struct {
+	gorm.Model
+	JobPostID    uint `json:"jobPostID" gorm:"uniqueIndex:idx_job_post_dismissal_post_specialist"`
+	SpecialistID uint `json:"specialistID" gorm:"uniqueIndex:idx_job_post_dismissal_post_specialist"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type JobRun struct {
+	gorm.Model
+	Name           string     `json:"name" gorm:"index"`
+	Trigger        string     `json:"trigger"`
+	Status         string     `json:"status"`
+	StartedAt      time.Time  `json:"startedAt"`
+	EndedAt        *time.Time `json:"endedAt"`
+	ItemsProcessed int        `json:"itemsProcessed"`
+	ErrorCount     int        `json:"errorCount"`
+	Errors         string     `json:"errors"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"jotno-server/money"
+
+	"gorm.io/gorm"
+)
+
+type LedgerEntry struct {
+	gorm.Model
+	TransactionID uint        `json:"transactionID" gorm:"index"`
+	Account       string      `json:"account" gorm:"index:idx_ledger_entry_account_specialist"`
+	SpecialistID  uint        `json:"specialistID" gorm:"index:idx_ledger_entry_account_specialist"`
+	UserID        uint        `json:"userID"`
+	BillID        *uint       `json:"billID" gorm:"index"`
+	Amount        money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
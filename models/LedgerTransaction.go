@@ -0,0 +1,18 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type LedgerTransaction struct {
+	gorm.Model
+	Key          string        `json:"key" gorm:"uniqueIndex"`
+	Event        string        `json:"event" gorm:"index"`
+	Currency     string        `json:"currency"`
+	UserID       uint          `json:"userID"`
+	SpecialistID uint          `json:"specialistID"`
+	BookingID    *uint         `json:"bookingID"`
+	BillID       *uint         `json:"billID" gorm:"index"`
+	Memo         string        `json:"memo"`
+	Entries      []LedgerEntry `json:"entries" gorm:"foreignKey:TransactionID"`
+}
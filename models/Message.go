@@ -1,13 +1,22 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Message spells out gorm.Model's fields so ID and CreatedAt can join ChatID
+// in the index that chat history pages through.
 type Message struct {
-	gorm.Model
-	ChatID     uint   `json:"chatID"`
-	SenderID   uint   `json:"senderID"`
-	ReceiverID uint   `json:"receiverID"`
-	Text       string `json:"text"`
+	ID         uint      `gorm:"primarykey;index:idx_message_chat_created_id,priority:3"`
+	CreatedAt  time.Time `gorm:"index:idx_message_chat_created_id,priority:2"`
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	ChatID     uint           `json:"chatID" gorm:"index:idx_message_chat_created_id,priority:1"`
+	SenderID   uint           `json:"senderID"`
+	SenderRole string         `json:"senderRole"`
+	ReceiverID uint           `json:"receiverID"`
+	Text       string         `json:"text"`
+	EditedAt   *time.Time     `json:"editedAt"`
 }
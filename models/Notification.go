@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+type Notification struct {
+	gorm.Model
+	RecipientID   uint   `json:"recipientID" gorm:"index:idx_notification_recipient"`
+	RecipientRole string `json:"recipientRole" gorm:"index:idx_notification_recipient"`
+	Title         string `json:"title"`
+	Body          string `json:"body"`
+	Link          string `json:"link"`
+	Read          bool   `json:"read"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"jotno-server/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PaymentAttempt struct {
+	gorm.Model
+	BillID            uint        `json:"billID" gorm:"index"`
+	UserID            uint        `json:"userID" gorm:"index"`
+	Provider          string      `json:"provider"`
+	Method            string      `json:"method"`
+	Reference         string      `json:"reference" gorm:"uniqueIndex"`
+	ProviderReference string      `json:"providerReference"`
+	Amount            money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Status            string      `json:"status" gorm:"index"`
+	RedirectURL       string      `json:"redirectURL"`
+	CheckoutPayload   string      `json:"-"`
+	WebhookPayload    string      `json:"-"`
+	PaidAt            *time.Time  `json:"paidAt"`
+}
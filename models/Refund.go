@@ -0,0 +1,19 @@
+package models
+
+import (
+	"jotno-server/money"
+
+	"gorm.io/gorm"
+)
+
+type Refund struct {
+	gorm.Model
+	BillID            uint        `json:"billID" gorm:"index"`
+	DisputeID         uint        `json:"disputeID" gorm:"uniqueIndex"`
+	PaymentAttemptID  *uint       `json:"paymentAttemptID"`
+	Provider          string      `json:"provider"`
+	Amount            money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Status            string      `json:"status"`
+	ProviderReference string      `json:"providerReference"`
+	RawResponse       string      `json:"-"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type ReminderLog struct {
+	gorm.Model
+	Key           string `json:"key" gorm:"uniqueIndex"`
+	BillID        *uint  `json:"billID" gorm:"index"`
+	RecipientID   uint   `json:"recipientID"`
+	RecipientRole string `json:"recipientRole"`
+	Kind          string `json:"kind"`
+	Channel       string `json:"channel"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"jotno-server/money"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type SavedSearch struct {
+	gorm.Model
+	SpecialistID  uint           `json:"specialistID" gorm:"index"`
+	Name          string         `json:"name"`
+	JobTypes      datatypes.JSON `json:"jobTypes"`
+	Lat           float32        `json:"lat"`
+	Lon           float32        `json:"lon"`
+	RadiusKm      float64        `json:"radiusKm"`
+	MinWage       money.Money    `json:"minWage" gorm:"embedded;embeddedPrefix:min_wage_"`
+	WageFrequency string         `json:"wageFrequency"`
+	Channel       string         `json:"channel"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Substitution struct {
+	gorm.Model
+	BookingID              uint       `json:"bookingID" gorm:"index"`
+	OriginalSpecialistID   uint       `json:"originalSpecialistID" gorm:"index"`
+	SubstituteSpecialistID *uint      `json:"substituteSpecialistID" gorm:"index"`
+	Kind                   string     `json:"kind"`
+	Status                 string     `json:"status"`
+	Reason                 string     `json:"reason"`
+	StartsAt               time.Time  `json:"startsAt"`
+	EndsAt                 *time.Time `json:"endsAt"`
+	OfferedPermanent       bool       `json:"offeredPermanent"`
+	ApprovedAt             *time.Time `json:"approvedAt"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Visit struct {
+	gorm.Model
+	BookingID         uint       `json:"bookingID" gorm:"index;uniqueIndex:idx_visit_open_booking,where:check_out_at IS NULL"`
+	SpecialistID      uint       `json:"specialistID" gorm:"index"`
+	CheckInAt         time.Time  `json:"checkInAt" gorm:"index"`
+	CheckInLat        float32    `json:"checkInLat"`
+	CheckInLon        float32    `json:"checkInLon"`
+	CheckInDistanceM  float64    `json:"checkInDistanceM"`
+	CheckOutAt        *time.Time `json:"checkOutAt"`
+	CheckOutLat       float32    `json:"checkOutLat"`
+	CheckOutLon       float32    `json:"checkOutLon"`
+	CheckOutDistanceM float64    `json:"checkOutDistanceM"`
+	LocationVerified  bool       `json:"locationVerified"`
+}
@@ -0,0 +1,137 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+)
+
+// Money is an amount in the minor unit of its currency, e.g. poisha for BDT.
+// Models embed it with a column prefix, giving <prefix>minor and
+// <prefix>currency columns.
+type Money struct {
+	Minor    int64  `json:"minor" validate:"gte=0"`
+	Currency string `json:"currency" validate:"currency"`
+}
+
+func New(minor int64, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	if !IsCurrency(currency) {
+		return Money{}, ErrUnknownCurrency
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(other.Neg())
+}
+
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than other.
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Minor < other.Minor:
+		return -1, nil
+	case m.Minor > other.Minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+// Scale multiplies the amount by ratio, rounding half away from zero to the
+// nearest minor unit.
+func (m Money) Scale(ratio float64) Money {
+	return Money{Minor: int64(math.Round(float64(m.Minor) * ratio)), Currency: m.Currency}
+}
+
+func (m Money) Percent(percent float64) Money {
+	return m.Scale(percent / 100)
+}
+
+// Major formats the amount in major units, e.g. "1234.50".
+func (m Money) Major() string {
+	exponent, _ := Exponent(m.Currency)
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	digits := strconv.FormatInt(minor, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// String formats the amount for people, e.g. "1,234.50 BDT".
+func (m Money) String() string {
+	major := m.Major()
+	sign := ""
+	if strings.HasPrefix(major, "-") {
+		sign, major = "-", major[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(major, ".")
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	if hasFraction {
+		whole += "." + fraction
+	}
+	return sign + whole + " " + m.Currency
+}
+
+// ParseMajor reads an amount written in major units, such as "1234.50", the
+// way payment providers report it.
+func ParseMajor(amount, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	exponent, ok := Exponent(currency)
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	trimmed := strings.TrimRight(fraction, "0")
+	if len(trimmed) > exponent {
+		return Money{}, errors.New("amount has more decimals than " + currency + " allows")
+	}
+	digits := whole + trimmed + strings.Repeat("0", exponent-len(trimmed))
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
@@ -0,0 +1,237 @@
+package money
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		exponent int
+	}{
+		{"BDT", 2},
+		{"USD", 2},
+		{"JPY", 0},
+		{"KWD", 3},
+		{"CLF", 4},
+	}
+	for _, test := range tests {
+		exponent, ok := Exponent(test.currency)
+		if !ok || exponent != test.exponent {
+			t.Errorf("Exponent(%q) = %d, %v; want %d, true", test.currency, exponent, ok, test.exponent)
+		}
+	}
+	if _, ok := Exponent("XXX"); ok {
+		t.Error(`Exponent("XXX") reported a currency`)
+	}
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(150, "bdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (Money{Minor: 150, Currency: "BDT"}) {
+		t.Errorf("New(150, bdt) = %+v", m)
+	}
+	if _, err := New(150, "XXX"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("New with an unknown currency returned %v", err)
+	}
+}
+
+func TestMajor(t *testing.T) {
+	tests := []struct {
+		money Money
+		major string
+	}{
+		{Money{Minor: 123450, Currency: "BDT"}, "1234.50"},
+		{Money{Minor: 5, Currency: "BDT"}, "0.05"},
+		{Money{Minor: 0, Currency: "BDT"}, "0.00"},
+		{Money{Minor: -250, Currency: "USD"}, "-2.50"},
+		{Money{Minor: 1500, Currency: "JPY"}, "1500"},
+		{Money{Minor: 1234, Currency: "KWD"}, "1.234"},
+		{Money{Minor: 7, Currency: "KWD"}, "0.007"},
+		{Money{Minor: 10000, Currency: "CLF"}, "1.0000"},
+	}
+	for _, test := range tests {
+		if major := test.money.Major(); major != test.major {
+			t.Errorf("%+v.Major() = %q; want %q", test.money, major, test.major)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		text  string
+	}{
+		{Money{Minor: 123456789, Currency: "BDT"}, "1,234,567.89 BDT"},
+		{Money{Minor: 99900, Currency: "BDT"}, "999.00 BDT"},
+		{Money{Minor: -100000, Currency: "USD"}, "-1,000.00 USD"},
+		{Money{Minor: 1000000, Currency: "JPY"}, "1,000,000 JPY"},
+	}
+	for _, test := range tests {
+		if text := test.money.String(); text != test.text {
+			t.Errorf("%+v.String() = %q; want %q", test.money, text, test.text)
+		}
+	}
+}
+
+func TestParseMajor(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		minor    int64
+	}{
+		{"1234.50", "BDT", 123450},
+		{"1234.5", "bdt", 123450},
+		{"1234", "BDT", 123400},
+		{" 10.00 ", "USD", 1000},
+		{"0.05", "BDT", 5},
+		{"1500", "JPY", 1500},
+		{"1500.00", "JPY", 1500},
+		{"1.234", "KWD", 1234},
+		{"1.2", "KWD", 1200},
+		{"-2.50", "USD", -250},
+	}
+	for _, test := range tests {
+		m, err := ParseMajor(test.amount, test.currency)
+		if err != nil {
+			t.Errorf("ParseMajor(%q, %q) failed: %v", test.amount, test.currency, err)
+			continue
+		}
+		if m.Minor != test.minor {
+			t.Errorf("ParseMajor(%q, %q) = %d; want %d", test.amount, test.currency, m.Minor, test.minor)
+		}
+	}
+
+	for _, amount := range []string{"1.234", "abc", "1.2.3"} {
+		if _, err := ParseMajor(amount, "BDT"); err == nil {
+			t.Errorf("ParseMajor(%q, BDT) did not fail", amount)
+		}
+	}
+	if _, err := ParseMajor("1.5", "JPY"); err == nil {
+		t.Error("ParseMajor(1.5, JPY) did not fail")
+	}
+	if _, err := ParseMajor("1.00", "XXX"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("ParseMajor with an unknown currency returned %v", err)
+	}
+}
+
+func TestParseMajorRoundTrip(t *testing.T) {
+	for _, m := range []Money{
+		{Minor: 123450, Currency: "BDT"},
+		{Minor: 1, Currency: "USD"},
+		{Minor: 1500, Currency: "JPY"},
+		{Minor: 1234, Currency: "KWD"},
+	} {
+		parsed, err := ParseMajor(m.Major(), m.Currency)
+		if err != nil || parsed != m {
+			t.Errorf("ParseMajor(%q) = %+v, %v; want %+v", m.Major(), parsed, err, m)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := Money{Minor: 1000, Currency: "BDT"}
+	b := Money{Minor: 250, Currency: "BDT"}
+
+	sum, err := a.Add(b)
+	if err != nil || sum.Minor != 1250 {
+		t.Errorf("Add = %+v, %v", sum, err)
+	}
+	difference, err := b.Sub(a)
+	if err != nil || difference.Minor != -750 {
+		t.Errorf("Sub = %+v, %v", difference, err)
+	}
+	if _, err := a.Add(Money{Minor: 1, Currency: "USD"}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies returned %v", err)
+	}
+	if cmp, err := a.Cmp(b); err != nil || cmp != 1 {
+		t.Errorf("Cmp = %d, %v; want 1", cmp, err)
+	}
+	if !Zero("BDT").IsZero() || Zero("BDT").IsPositive() {
+		t.Error("Zero is not zero")
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		minor int64
+		ratio float64
+		want  int64
+	}{
+		{1000, 0.5, 500},
+		{1001, 0.5, 501},
+		{-1001, 0.5, -501},
+		{999, 1.0 / 3, 333},
+		{100, 0, 0},
+	}
+	for _, test := range tests {
+		scaled := Money{Minor: test.minor, Currency: "BDT"}.Scale(test.ratio)
+		if scaled.Minor != test.want {
+			t.Errorf("Scale(%d, %g) = %d; want %d", test.minor, test.ratio, scaled.Minor, test.want)
+		}
+	}
+	if fee := (Money{Minor: 20000, Currency: "BDT"}).Percent(12.5); fee.Minor != 2500 {
+		t.Errorf("Percent(12.5) = %d; want 2500", fee.Minor)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"base": "usd", "rates": {"bdt": 120, "JPY": 150, "KWD": 0.3}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRates(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ratesLock.Lock()
+		rates = nil
+		ratesLock.Unlock()
+	})
+
+	tests := []struct {
+		from Money
+		to   string
+		want Money
+	}{
+		{Money{Minor: 1000, Currency: "USD"}, "BDT", Money{Minor: 120000, Currency: "BDT"}},
+		{Money{Minor: 120000, Currency: "BDT"}, "usd", Money{Minor: 1000, Currency: "USD"}},
+		{Money{Minor: 1000, Currency: "USD"}, "JPY", Money{Minor: 1500, Currency: "JPY"}},
+		{Money{Minor: 1500, Currency: "JPY"}, "KWD", Money{Minor: 3000, Currency: "KWD"}},
+		{Money{Minor: 42, Currency: "BDT"}, "BDT", Money{Minor: 42, Currency: "BDT"}},
+	}
+	for _, test := range tests {
+		converted, err := Convert(test.from, test.to)
+		if err != nil || converted != test.want {
+			t.Errorf("Convert(%+v, %s) = %+v, %v; want %+v", test.from, test.to, converted, err, test.want)
+		}
+	}
+	if _, err := Convert(Money{Minor: 1, Currency: "EUR"}, "BDT"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert without a rate returned %v", err)
+	}
+}
+
+func TestMinorFactorSQL(t *testing.T) {
+	sql := MinorFactorSQL("currency")
+	for _, part := range []string{
+		"WHEN UPPER(currency) IN (",
+		"'JPY'",
+		"THEN 1 ",
+		"'KWD'",
+		"THEN 1000 ",
+		"'CLF', 'UYW') THEN 10000",
+		"ELSE 100 END",
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("MinorFactorSQL is missing %q: %s", part, sql)
+		}
+	}
+}
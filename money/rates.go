@@ -0,0 +1,98 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoRate = errors.New("no exchange rate for currency")
+
+// Rates are units of each currency per one unit of Base. They are only good
+// enough for showing approximate prices, never for charging.
+type Rates struct {
+	Base      string             `json:"base"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+var (
+	rates     *Rates
+	ratesLock sync.RWMutex
+)
+
+// LoadRates replaces the rates table with the JSON file at path, e.g.
+// {"base": "USD", "rates": {"BDT": 119.5, "EUR": 0.92}}.
+func LoadRates(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var loaded Rates
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return err
+	}
+
+	loaded.Base = strings.ToUpper(loaded.Base)
+	if !IsCurrency(loaded.Base) {
+		return ErrUnknownCurrency
+	}
+	normalized := map[string]float64{loaded.Base: 1}
+	for code, rate := range loaded.Rates {
+		code = strings.ToUpper(code)
+		if !IsCurrency(code) || rate <= 0 {
+			return errors.New("invalid exchange rate for " + code)
+		}
+		normalized[code] = rate
+	}
+	loaded.Rates = normalized
+
+	ratesLock.Lock()
+	rates = &loaded
+	ratesLock.Unlock()
+	return nil
+}
+
+// InitializeRates loads the table at EXCHANGE_RATES_PATH when it is set.
+// Without rates, amounts are only ever shown in their own currency.
+func InitializeRates() {
+	path := os.Getenv("EXCHANGE_RATES_PATH")
+	if path == "" {
+		return
+	}
+	if err := LoadRates(path); err != nil {
+		log.Println("exchange rates not loaded:", err)
+	}
+}
+
+// Convert changes m into another currency using the loaded rates.
+func Convert(m Money, to string) (Money, error) {
+	to = strings.ToUpper(to)
+	if m.Currency == to {
+		return m, nil
+	}
+
+	ratesLock.RLock()
+	current := rates
+	ratesLock.RUnlock()
+	if current == nil {
+		return Money{}, ErrNoRate
+	}
+	fromRate, fromOK := current.Rates[m.Currency]
+	toRate, toOK := current.Rates[to]
+	if !fromOK || !toOK {
+		return Money{}, ErrNoRate
+	}
+
+	fromExponent, _ := Exponent(m.Currency)
+	toExponent, _ := Exponent(to)
+	major := float64(m.Minor) / math.Pow10(fromExponent) / fromRate * toRate
+	return Money{Minor: int64(math.Round(major * math.Pow10(toExponent))), Currency: to}, nil
+}
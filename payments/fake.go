@@ -0,0 +1,72 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Fake is a local provider for development. Checkout sends the customer
+// straight back to the return URL, and webhooks are JSON bodies signed with
+// an HMAC-SHA256 of the body in the X-Fake-Signature header.
+type Fake struct {
+	secret string
+}
+
+func NewFake(secret string) *Fake {
+	return &Fake{secret: secret}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) StartCheckout(req CheckoutRequest) (*Checkout, error) {
+	redirectURL, err := url.Parse(req.ReturnURL)
+	if err != nil {
+		return nil, err
+	}
+	query := redirectURL.Query()
+	query.Set("reference", req.Reference)
+	redirectURL.RawQuery = query.Encode()
+
+	return &Checkout{
+		RedirectURL:       redirectURL.String(),
+		ProviderReference: "fake-" + req.Reference,
+		RawResponse:       "{}",
+	}, nil
+}
+
+func (f *Fake) ParseWebhook(r *http.Request) (*WebhookEvent, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(f.secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Fake-Signature"))) {
+		return nil, ErrInvalidSignature
+	}
+
+	event := &WebhookEvent{}
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		return nil, err
+	}
+	event.RawPayload = string(body)
+	return event, nil
+}
+
+func (f *Fake) Refund(req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{
+		ProviderReference: "fake-refund-" + req.Reference,
+		Status:            StatusSucceeded,
+		RawResponse:       "{}",
+	}, nil
+}
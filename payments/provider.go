@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"errors"
+	"jotno-server/money"
+	"net/http"
+	"os"
+)
+
+const (
+	MethodCard   = "card"
+	MethodBkash  = "bkash"
+	MethodNagad  = "nagad"
+	MethodRocket = "rocket"
+)
+
+// A payment attempt is refundDue when the provider took the money for a bill
+// that had already been settled some other way, and refunded once the
+// provider has accepted handing it back.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusRefundDue = "refundDue"
+	StatusRefunded  = "refunded"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Provider is a payment gateway that can take a customer through checkout and
+// report the outcome back through a signed webhook.
+type Provider interface {
+	Name() string
+	StartCheckout(req CheckoutRequest) (*Checkout, error)
+	// ParseWebhook verifies the webhook signature and reads the payment
+	// outcome. It returns ErrInvalidSignature when the request is not genuine.
+	ParseWebhook(r *http.Request) (*WebhookEvent, error)
+	// Refund hands back part or all of a successful payment.
+	Refund(req RefundRequest) (*RefundResult, error)
+}
+
+type CheckoutRequest struct {
+	Reference     string
+	Amount        money.Money
+	Method        string
+	Description   string
+	CustomerName  string
+	CustomerEmail string
+	CustomerPhone string
+	CustomerCity  string
+	ReturnURL     string
+	WebhookURL    string
+}
+
+type Checkout struct {
+	RedirectURL       string
+	ProviderReference string
+	RawResponse       string
+}
+
+type WebhookEvent struct {
+	Reference         string      `json:"reference"`
+	ProviderReference string      `json:"providerReference"`
+	Status            string      `json:"status"`
+	Amount            money.Money `json:"amount"`
+	RawPayload        string      `json:"-"`
+}
+
+type RefundRequest struct {
+	Reference         string
+	ProviderReference string
+	Amount            money.Money
+	Reason            string
+}
+
+// RefundResult is pending when the provider accepted the refund but has not
+// paid it out yet.
+type RefundResult struct {
+	ProviderReference string
+	Status            string
+	RawResponse       string
+}
+
+var providers = map[string]Provider{}
+
+// Register makes a provider available by name. It is meant to be called while
+// the server starts.
+func Register(provider Provider) {
+	providers[provider.Name()] = provider
+}
+
+func Get(name string) (Provider, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Default returns the provider named by PAYMENT_PROVIDER.
+func Default() (Provider, error) {
+	return Get(os.Getenv("PAYMENT_PROVIDER"))
+}
+
+// InitializeProviders registers the SSLCommerz provider when its store
+// credentials are set and the fake provider when FAKE_PAYMENT_SECRET is set.
+func InitializeProviders() {
+	if os.Getenv("SSLCOMMERZ_STORE_ID") != "" {
+		Register(NewSSLCommerz(
+			os.Getenv("SSLCOMMERZ_STORE_ID"),
+			os.Getenv("SSLCOMMERZ_STORE_PASSWORD"),
+			os.Getenv("SSLCOMMERZ_SANDBOX") == "true",
+		))
+	}
+	if os.Getenv("FAKE_PAYMENT_SECRET") != "" {
+		Register(NewFake(os.Getenv("FAKE_PAYMENT_SECRET")))
+	}
+}
@@ -0,0 +1,293 @@
+package payments
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"jotno-server/money"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SSLCommerz takes cards and the bKash, Nagad and Rocket mobile wallets
+// through the SSLCommerz hosted checkout and confirms payments by IPN.
+type SSLCommerz struct {
+	storeID       string
+	storePassword string
+	baseURL       string
+	client        *http.Client
+}
+
+// sslCommerzSignedFields must all be covered by an IPN's signature, or the
+// amount and outcome could be changed without breaking it.
+var sslCommerzSignedFields = []string{"tran_id", "amount", "currency", "status"}
+
+// sslCommerzWallets maps our payment methods to SSLCommerz gateway names so
+// checkout opens straight on the chosen wallet.
+var sslCommerzWallets = map[string]string{
+	MethodBkash:  "bkash",
+	MethodNagad:  "nagad",
+	MethodRocket: "dbbl_mobile",
+}
+
+func NewSSLCommerz(storeID string, storePassword string, sandbox bool) *SSLCommerz {
+	baseURL := "https://securepay.sslcommerz.com"
+	if sandbox {
+		baseURL = "https://sandbox.sslcommerz.com"
+	}
+	return &SSLCommerz{
+		storeID:       storeID,
+		storePassword: storePassword,
+		baseURL:       baseURL,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *SSLCommerz) Name() string {
+	return "sslcommerz"
+}
+
+func (s *SSLCommerz) StartCheckout(req CheckoutRequest) (*Checkout, error) {
+	form := url.Values{}
+	form.Set("store_id", s.storeID)
+	form.Set("store_passwd", s.storePassword)
+	form.Set("total_amount", req.Amount.Major())
+	form.Set("currency", req.Amount.Currency)
+	form.Set("tran_id", req.Reference)
+	form.Set("success_url", req.ReturnURL)
+	form.Set("fail_url", req.ReturnURL)
+	form.Set("cancel_url", req.ReturnURL)
+	form.Set("ipn_url", req.WebhookURL)
+	form.Set("cus_name", req.CustomerName)
+	form.Set("cus_email", req.CustomerEmail)
+	form.Set("cus_phone", req.CustomerPhone)
+	form.Set("cus_add1", req.CustomerCity)
+	form.Set("cus_city", req.CustomerCity)
+	form.Set("cus_country", "Bangladesh")
+	form.Set("shipping_method", "NO")
+	form.Set("product_name", req.Description)
+	form.Set("product_category", "service")
+	form.Set("product_profile", "non-physical-goods")
+	if wallet, ok := sslCommerzWallets[req.Method]; ok {
+		form.Set("multi_card_name", wallet)
+	}
+
+	res, err := s.client.PostForm(s.baseURL+"/gwprocess/v4/api.php", form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var session struct {
+		Status         string `json:"status"`
+		FailedReason   string `json:"failedreason"`
+		SessionKey     string `json:"sessionkey"`
+		GatewayPageURL string `json:"GatewayPageURL"`
+	}
+	err = json.Unmarshal(body, &session)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != "SUCCESS" {
+		return nil, errors.New("sslcommerz: " + session.FailedReason)
+	}
+
+	return &Checkout{
+		RedirectURL:       session.GatewayPageURL,
+		ProviderReference: session.SessionKey,
+		RawResponse:       string(body),
+	}, nil
+}
+
+// ParseWebhook reads an IPN. SSLCommerz signs the fields listed in verify_key
+// together with the MD5 of the store password, sorted by name. A successful
+// payment is only taken as such once the validation API confirms it.
+func (s *SSLCommerz) ParseWebhook(r *http.Request) (*WebhookEvent, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	form := r.PostForm
+
+	if !s.verifySign(form) {
+		return nil, ErrInvalidSignature
+	}
+
+	amount, err := money.ParseMajor(form.Get("amount"), form.Get("currency"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Refunds are made against the bank transaction, so that is the
+	// reference kept for the payment.
+	event := &WebhookEvent{
+		Reference:         form.Get("tran_id"),
+		ProviderReference: form.Get("bank_tran_id"),
+		Amount:            amount,
+		RawPayload:        form.Encode(),
+	}
+	switch form.Get("status") {
+	case "VALID", "VALIDATED":
+		event.Status = StatusSucceeded
+	case "CANCELLED":
+		event.Status = StatusCancelled
+	default:
+		event.Status = StatusFailed
+	}
+	if event.Status != StatusSucceeded {
+		return event, nil
+	}
+
+	validated, err := s.validate(form.Get("val_id"))
+	if err != nil {
+		return nil, err
+	}
+	if !validated.valid() || validated.TranID != event.Reference || validated.Amount != event.Amount {
+		event.Status = StatusFailed
+	}
+	return event, nil
+}
+
+// sslCommerzValidation is the validation API's account of a payment.
+type sslCommerzValidation struct {
+	Status string
+	TranID string
+	Amount money.Money
+}
+
+func (v *sslCommerzValidation) valid() bool {
+	return v.Status == "VALID" || v.Status == "VALIDATED"
+}
+
+// validate asks the validation API about the payment an IPN reports.
+func (s *SSLCommerz) validate(valID string) (*sslCommerzValidation, error) {
+	if valID == "" {
+		return nil, errors.New("sslcommerz: the IPN has no val_id")
+	}
+	query := url.Values{}
+	query.Set("val_id", valID)
+	query.Set("store_id", s.storeID)
+	query.Set("store_passwd", s.storePassword)
+	query.Set("format", "json")
+
+	res, err := s.client.Get(s.baseURL + "/validator/api/validationserverAPI.php?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var validation struct {
+		Status   string `json:"status"`
+		TranID   string `json:"tran_id"`
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	err = json.Unmarshal(body, &validation)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sslCommerzValidation{Status: validation.Status, TranID: validation.TranID}
+	if result.valid() {
+		result.Amount, err = money.ParseMajor(validation.Amount, validation.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *SSLCommerz) Refund(req RefundRequest) (*RefundResult, error) {
+	query := url.Values{}
+	query.Set("store_id", s.storeID)
+	query.Set("store_passwd", s.storePassword)
+	query.Set("bank_tran_id", req.ProviderReference)
+	query.Set("refund_amount", req.Amount.Major())
+	query.Set("refund_remarks", req.Reason)
+	query.Set("refe_id", req.Reference)
+	query.Set("format", "json")
+
+	res, err := s.client.Get(s.baseURL + "/validator/api/merchantTransIDvalidationAPI.php?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var refund struct {
+		APIConnect  string `json:"APIConnect"`
+		Status      string `json:"status"`
+		RefundRefID string `json:"refund_ref_id"`
+		ErrorReason string `json:"errorReason"`
+	}
+	err = json.Unmarshal(body, &refund)
+	if err != nil {
+		return nil, err
+	}
+	if refund.APIConnect != "DONE" || (refund.Status != "success" && refund.Status != "processing") {
+		return nil, errors.New("sslcommerz refund: " + refund.ErrorReason)
+	}
+
+	result := &RefundResult{
+		ProviderReference: refund.RefundRefID,
+		Status:            StatusSucceeded,
+		RawResponse:       string(body),
+	}
+	if refund.Status == "processing" {
+		result.Status = StatusPending
+	}
+	return result, nil
+}
+
+func (s *SSLCommerz) verifySign(form url.Values) bool {
+	verifySign := form.Get("verify_sign")
+	verifyKey := form.Get("verify_key")
+	if verifySign == "" || verifyKey == "" {
+		return false
+	}
+
+	passwordHash := md5.Sum([]byte(s.storePassword))
+	fields := map[string]string{"store_passwd": hex.EncodeToString(passwordHash[:])}
+	for _, key := range strings.Split(verifyKey, ",") {
+		fields[key] = form.Get(key)
+	}
+	for _, key := range sslCommerzSignedFields {
+		if _, signed := fields[key]; !signed {
+			return false
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+fields[key])
+	}
+	expected := md5.Sum([]byte(strings.Join(pairs, "&")))
+
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(expected[:])), []byte(verifySign)) == 1
+}
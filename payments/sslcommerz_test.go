@@ -0,0 +1,57 @@
+package payments
+
+import (
+	"net/url"
+	"testing"
+)
+
+// signedIPN is an IPN signed with the store password "secret", its
+// verify_sign worked out by hand from the fields verify_key lists.
+func signedIPN() url.Values {
+	return url.Values{
+		"tran_id":     {"B42"},
+		"val_id":      {"V1"},
+		"amount":      {"1500.00"},
+		"currency":    {"BDT"},
+		"status":      {"VALID"},
+		"card_type":   {"BKASH-BKash"},
+		"verify_key":  {"amount,currency,status,tran_id,val_id"},
+		"verify_sign": {"db5be1fed1f2929d86b0fea6b573c455"},
+	}
+}
+
+func TestVerifySign(t *testing.T) {
+	provider := NewSSLCommerz("store", "secret", true)
+
+	if !provider.verifySign(signedIPN()) {
+		t.Fatal("a correctly signed IPN was rejected")
+	}
+
+	tests := []struct {
+		name   string
+		change func(form url.Values)
+		valid  bool
+	}{
+		{"unsigned field changed", func(form url.Values) { form.Set("card_type", "VISA") }, true},
+		{"amount changed", func(form url.Values) { form.Set("amount", "15.00") }, false},
+		{"status changed", func(form url.Values) { form.Set("status", "FAILED") }, false},
+		{"signature changed", func(form url.Values) { form.Set("verify_sign", "cc08caf4bdd954ce9628692869ca9ecb") }, false},
+		{"no signature", func(form url.Values) { form.Del("verify_sign") }, false},
+		{"no signed fields", func(form url.Values) { form.Del("verify_key") }, false},
+		{"amount left out of the signature", func(form url.Values) {
+			form.Set("verify_key", "currency,status,tran_id,val_id")
+			form.Set("verify_sign", "cc08caf4bdd954ce9628692869ca9ecb")
+		}, false},
+	}
+	for _, test := range tests {
+		form := signedIPN()
+		test.change(form)
+		if valid := provider.verifySign(form); valid != test.valid {
+			t.Errorf("%s: verifySign = %v; want %v", test.name, valid, test.valid)
+		}
+	}
+
+	if NewSSLCommerz("store", "other", true).verifySign(signedIPN()) {
+		t.Error("an IPN signed with another store's password was accepted")
+	}
+}
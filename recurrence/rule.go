@@ -0,0 +1,203 @@
+package recurrence
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+var (
+	ErrInvalidRule     = errors.New("invalid RRULE")
+	ErrUnsupportedPart = errors.New("unsupported RRULE part")
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Weekday is a BYDAY entry. N picks the nth such weekday of the month, or of
+// the year for yearly rules without BYMONTH, counting from the end when
+// negative. Zero means every one.
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+// Rule is the subset of an RFC 5545 RRULE that bookings use: FREQ, INTERVAL,
+// COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH. Rules repeat whole days; the
+// time of day comes from the schedule. An UNTIL given as a date-time is kept
+// in Until; one given as a plain date is kept in UntilDate, as a calendar day
+// in whatever timezone the rule is scheduled in.
+type Rule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	UntilDate  *time.Time
+	ByDay      []Weekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// Parse reads an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR". A
+// leading "RRULE:" is allowed.
+func Parse(value string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return rule, ErrInvalidRule
+	}
+
+	for _, part := range strings.Split(value, ";") {
+		name, val, found := strings.Cut(part, "=")
+		if !found || val == "" {
+			return rule, ErrInvalidRule
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			if rule.Freq != Daily && rule.Freq != Weekly && rule.Freq != Monthly && rule.Freq != Yearly {
+				return rule, ErrUnsupportedPart
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+			if err != nil || rule.Interval < 1 {
+				return rule, ErrInvalidRule
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+			if err != nil || rule.Count < 1 {
+				return rule, ErrInvalidRule
+			}
+		case "UNTIL":
+			if until, err := time.Parse("20060102T150405Z", val); err == nil {
+				rule.Until = &until
+				break
+			}
+			untilDate, err := time.Parse("20060102", val)
+			if err != nil {
+				return rule, ErrInvalidRule
+			}
+			rule.UntilDate = &untilDate
+		case "BYDAY":
+			for _, code := range strings.Split(val, ",") {
+				weekday, err := parseWeekday(code)
+				if err != nil {
+					return rule, err
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return rule, ErrInvalidRule
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return rule, ErrInvalidRule
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				return rule, ErrUnsupportedPart
+			}
+		default:
+			return rule, ErrUnsupportedPart
+		}
+	}
+
+	if rule.Freq == "" || (rule.Count > 0 && (rule.Until != nil || rule.UntilDate != nil)) {
+		return rule, ErrInvalidRule
+	}
+	return rule, nil
+}
+
+// String writes the rule back in a canonical form.
+func (rule Rule) String() string {
+	parts := []string{"FREQ=" + rule.Freq}
+	if rule.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rule.Interval))
+	}
+	if rule.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rule.Count))
+	}
+	if rule.Until != nil {
+		parts = append(parts, "UNTIL="+rule.Until.UTC().Format("20060102T150405Z"))
+	}
+	if rule.UntilDate != nil {
+		parts = append(parts, "UNTIL="+rule.UntilDate.Format("20060102"))
+	}
+	if len(rule.ByDay) > 0 {
+		var days []string
+		for _, weekday := range rule.ByDay {
+			code := ""
+			if weekday.N != 0 {
+				code = strconv.Itoa(weekday.N)
+			}
+			for name, day := range weekdayCodes {
+				if day == weekday.Day {
+					code += name
+				}
+			}
+			days = append(days, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(rule.ByMonthDay) > 0 {
+		var days []string
+		for _, day := range rule.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(rule.ByMonth) > 0 {
+		var months []string
+		for _, month := range rule.ByMonth {
+			months = append(months, strconv.Itoa(int(month)))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+func parseWeekday(code string) (Weekday, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) < 2 {
+		return Weekday{}, ErrInvalidRule
+	}
+	day, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return Weekday{}, ErrInvalidRule
+	}
+
+	weekday := Weekday{Day: day}
+	if prefix := code[:len(code)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return Weekday{}, ErrInvalidRule
+		}
+		weekday.N = n
+	}
+	return weekday, nil
+}
@@ -0,0 +1,258 @@
+package recurrence
+
+import (
+	"errors"
+	"os"
+	"time"
+	_ "time/tzdata"
+)
+
+var ErrInvalidTime = errors.New("times must be written as HH:MM")
+
+// DefaultTimezone is where bookings that do not name a timezone take place,
+// set with BOOKING_TIMEZONE.
+func DefaultTimezone() string {
+	if timezone := os.Getenv("BOOKING_TIMEZONE"); timezone != "" {
+		return timezone
+	}
+	return "Asia/Dhaka"
+}
+
+// Occurrence is one visit the schedule calls for, over [Start, End).
+type Occurrence struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Schedule is a rule anchored to a first day, with the time window each
+// occurrence covers in the schedule's timezone. A window whose end is not
+// after its start runs past midnight, so "00:00" to "00:00" is the whole day.
+type Schedule struct {
+	Rule     Rule
+	First    time.Time
+	End      *time.Time
+	Location *time.Location
+	startMin int
+	duration time.Duration
+}
+
+// NewSchedule builds a schedule from an RRULE, the first date in the form
+// 2006-01-02, the daily window and an IANA timezone. end, when not zero, is
+// the moment the schedule stops.
+func NewSchedule(rrule string, firstDate string, startTime string, endTime string, timezone string, end time.Time) (*Schedule, error) {
+	rule, err := Parse(rrule)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	first, err := time.ParseInLocation(time.DateOnly, firstDate, location)
+	if err != nil {
+		return nil, err
+	}
+	startMin, err := ParseClock(startTime)
+	if err != nil {
+		return nil, err
+	}
+	endMin, err := ParseClock(endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	length := endMin - startMin
+	if length <= 0 {
+		length += 24 * 60
+	}
+	schedule := &Schedule{
+		Rule:     rule,
+		First:    first,
+		Location: location,
+		startMin: startMin,
+		duration: time.Duration(length) * time.Minute,
+	}
+	if !end.IsZero() {
+		schedule.End = &end
+	}
+	return schedule, nil
+}
+
+// ParseClock reads a time of day such as "09:30" as minutes after midnight.
+func ParseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, ErrInvalidTime
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Between lists the occurrences overlapping [from, to), in order.
+func (s *Schedule) Between(from time.Time, to time.Time) []Occurrence {
+	occurrences := []Occurrence{}
+	count := 0
+	for day := s.First; ; day = day.AddDate(0, 0, 1) {
+		start := time.Date(day.Year(), day.Month(), day.Day(), s.startMin/60, s.startMin%60, 0, 0, s.Location)
+		if !start.Before(to) || (s.End != nil && !start.Before(*s.End)) {
+			break
+		}
+		if s.Rule.Until != nil && start.After(*s.Rule.Until) {
+			break
+		}
+		if s.Rule.UntilDate != nil && daysBetween(*s.Rule.UntilDate, day) > 0 {
+			break
+		}
+		if !s.matches(day) {
+			continue
+		}
+		count++
+		if s.Rule.Count > 0 && count > s.Rule.Count {
+			break
+		}
+
+		occurrence := Occurrence{Start: start, End: start.Add(s.duration)}
+		if s.End != nil && occurrence.End.After(*s.End) {
+			occurrence.End = *s.End
+		}
+		if occurrence.End.After(from) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences
+}
+
+// Overlapping pairs up the occurrences of a and b that share any time. Both
+// lists must be in order.
+func Overlapping(a []Occurrence, b []Occurrence) [][2]Occurrence {
+	var pairs [][2]Occurrence
+	j := 0
+	for _, first := range a {
+		for j < len(b) && !b[j].End.After(first.Start) {
+			j++
+		}
+		for k := j; k < len(b) && b[k].Start.Before(first.End); k++ {
+			pairs = append(pairs, [2]Occurrence{first, b[k]})
+		}
+	}
+	return pairs
+}
+
+// matches reports whether the rule repeats on day. Parts that are not given
+// default to the first day's, as RFC 5545 describes.
+func (s *Schedule) matches(day time.Time) bool {
+	rule := s.Rule
+	if !s.onInterval(day) {
+		return false
+	}
+	if len(rule.ByMonth) > 0 && !containsMonth(rule.ByMonth, day.Month()) {
+		return false
+	}
+	if len(rule.ByMonthDay) > 0 && !matchesMonthDay(rule.ByMonthDay, day) {
+		return false
+	}
+	if len(rule.ByDay) > 0 && !s.matchesWeekday(day) {
+		return false
+	}
+
+	switch rule.Freq {
+	case Weekly:
+		if len(rule.ByDay) == 0 {
+			return day.Weekday() == s.First.Weekday()
+		}
+	case Monthly:
+		if len(rule.ByDay) == 0 && len(rule.ByMonthDay) == 0 {
+			return day.Day() == s.First.Day()
+		}
+	case Yearly:
+		// BYDAY and BYMONTHDAY spread over the whole year unless BYMONTH
+		// narrows it; with neither, the rule repeats on the first day's date.
+		if len(rule.ByDay) == 0 && len(rule.ByMonthDay) == 0 {
+			if len(rule.ByMonth) == 0 && day.Month() != s.First.Month() {
+				return false
+			}
+			return day.Day() == s.First.Day()
+		}
+	}
+	return true
+}
+
+// onInterval checks INTERVAL against the days, weeks, months or years since
+// the first day. Weeks start on Monday.
+func (s *Schedule) onInterval(day time.Time) bool {
+	if s.Rule.Interval <= 1 {
+		return true
+	}
+	var elapsed int
+	switch s.Rule.Freq {
+	case Daily:
+		elapsed = daysBetween(s.First, day)
+	case Weekly:
+		elapsed = daysBetween(weekStart(s.First), weekStart(day)) / 7
+	case Monthly:
+		elapsed = (day.Year()-s.First.Year())*12 + int(day.Month()-s.First.Month())
+	case Yearly:
+		elapsed = day.Year() - s.First.Year()
+	}
+	return elapsed%s.Rule.Interval == 0
+}
+
+func (s *Schedule) matchesWeekday(day time.Time) bool {
+	for _, weekday := range s.Rule.ByDay {
+		if weekday.Day != day.Weekday() {
+			continue
+		}
+		if weekday.N == 0 {
+			return true
+		}
+
+		// Ordinals count within the month, except for yearly rules that do
+		// not narrow the months.
+		var nth, fromEnd int
+		if s.Rule.Freq == Yearly && len(s.Rule.ByMonth) == 0 {
+			yearEnd := time.Date(day.Year(), time.December, 31, 0, 0, 0, 0, day.Location())
+			nth = (day.YearDay()-1)/7 + 1
+			fromEnd = (yearEnd.YearDay()-day.YearDay())/7 + 1
+		} else {
+			nth = (day.Day()-1)/7 + 1
+			fromEnd = (daysIn(day)-day.Day())/7 + 1
+		}
+		if weekday.N == nth || weekday.N == -fromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMonthDay(monthDays []int, day time.Time) bool {
+	for _, monthDay := range monthDays {
+		if monthDay == day.Day() || monthDay == day.Day()-daysIn(day)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(day time.Time) int {
+	return time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+}
+
+// daysBetween counts calendar days, which stays exact across DST changes.
+func daysBetween(from time.Time, to time.Time) int {
+	fromUTC := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toUTC := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toUTC.Sub(fromUTC).Hours() / 24)
+}
+
+func weekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
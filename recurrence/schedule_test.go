@@ -0,0 +1,455 @@
+package recurrence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	rule, err := Parse("RRULE:FREQ=weekly;INTERVAL=2;COUNT=5;BYDAY=MO,-1FR,2we")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Freq != Weekly || rule.Interval != 2 || rule.Count != 5 {
+		t.Errorf("Parse = %+v", rule)
+	}
+	wantDays := []Weekday{{Day: time.Monday}, {Day: time.Friday, N: -1}, {Day: time.Wednesday, N: 2}}
+	if len(rule.ByDay) != len(wantDays) {
+		t.Fatalf("BYDAY = %+v; want %+v", rule.ByDay, wantDays)
+	}
+	for i, weekday := range wantDays {
+		if rule.ByDay[i] != weekday {
+			t.Errorf("BYDAY[%d] = %+v; want %+v", i, rule.ByDay[i], weekday)
+		}
+	}
+
+	rule, err = Parse("FREQ=MONTHLY;BYMONTHDAY=1,-1;BYMONTH=1,7;WKST=MO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.ByMonthDay) != 2 || rule.ByMonthDay[1] != -1 || len(rule.ByMonth) != 2 || rule.ByMonth[1] != time.July {
+		t.Errorf("Parse = %+v", rule)
+	}
+	if rule.Interval != 1 {
+		t.Errorf("INTERVAL defaults to %d; want 1", rule.Interval)
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	rule, err := Parse("FREQ=DAILY;UNTIL=20260110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Until != nil || rule.UntilDate == nil || rule.UntilDate.Format(time.DateOnly) != "2026-01-10" {
+		t.Errorf("a plain UNTIL date was read as %v, %v; want the day 2026-01-10", rule.Until, rule.UntilDate)
+	}
+
+	rule, err = Parse("FREQ=DAILY;UNTIL=20260110T120000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+	if rule.UntilDate != nil || rule.Until == nil || !rule.Until.Equal(want) {
+		t.Errorf("UNTIL = %v; want %v", rule.Until, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		rrule string
+		err   error
+	}{
+		{"", ErrInvalidRule},
+		{"INTERVAL=2", ErrInvalidRule},
+		{"FREQ", ErrInvalidRule},
+		{"FREQ=DAILY;INTERVAL=0", ErrInvalidRule},
+		{"FREQ=DAILY;COUNT=-1", ErrInvalidRule},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20260110", ErrInvalidRule},
+		{"FREQ=DAILY;UNTIL=tomorrow", ErrInvalidRule},
+		{"FREQ=WEEKLY;BYDAY=XX", ErrInvalidRule},
+		{"FREQ=MONTHLY;BYDAY=0MO", ErrInvalidRule},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", ErrInvalidRule},
+		{"FREQ=YEARLY;BYMONTH=13", ErrInvalidRule},
+		{"FREQ=HOURLY", ErrUnsupportedPart},
+		{"FREQ=MONTHLY;BYSETPOS=1", ErrUnsupportedPart},
+		{"FREQ=WEEKLY;WKST=SU", ErrUnsupportedPart},
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.rrule); !errors.Is(err, test.err) {
+			t.Errorf("Parse(%q) returned %v; want %v", test.rrule, err, test.err)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, rrule := range []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2;COUNT=5;BYDAY=MO,-1FR",
+		"FREQ=MONTHLY;UNTIL=20260110T120000Z;BYMONTHDAY=1,-1",
+		"FREQ=WEEKLY;UNTIL=20260110;BYDAY=SA",
+		"FREQ=YEARLY;BYDAY=2SU;BYMONTH=5",
+	} {
+		rule, err := Parse(rrule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.String() != rrule {
+			t.Errorf("Parse(%q).String() = %q", rrule, rule.String())
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	minutes, err := ParseClock("09:30")
+	if err != nil || minutes != 570 {
+		t.Errorf("ParseClock(09:30) = %d, %v; want 570", minutes, err)
+	}
+	for _, clock := range []string{"24:00", "9am", "", "09:60"} {
+		if _, err := ParseClock(clock); !errors.Is(err, ErrInvalidTime) {
+			t.Errorf("ParseClock(%q) returned %v", clock, err)
+		}
+	}
+}
+
+// startDates expands a schedule over [from, to) and returns the day each
+// occurrence starts on.
+func startDates(t *testing.T, rrule string, firstDate string, from string, to string) []string {
+	t.Helper()
+	schedule, err := NewSchedule(rrule, firstDate, "09:00", "17:00", "Asia/Dhaka", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTime, _ := time.ParseInLocation(time.DateOnly, from, schedule.Location)
+	toTime, _ := time.ParseInLocation(time.DateOnly, to, schedule.Location)
+
+	dates := []string{}
+	for _, occurrence := range schedule.Between(fromTime, toTime) {
+		dates = append(dates, occurrence.Start.Format(time.DateOnly))
+	}
+	return dates
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name      string
+		rrule     string
+		firstDate string
+		from      string
+		to        string
+		want      []string
+	}{
+		{
+			name:      "daily",
+			rrule:     "FREQ=DAILY",
+			firstDate: "2026-01-05", from: "2026-01-01", to: "2026-01-08",
+			want: []string{"2026-01-05", "2026-01-06", "2026-01-07"},
+		},
+		{
+			name:      "every third day",
+			rrule:     "FREQ=DAILY;INTERVAL=3",
+			firstDate: "2026-01-05", from: "2026-01-05", to: "2026-01-15",
+			want: []string{"2026-01-05", "2026-01-08", "2026-01-11", "2026-01-14"},
+		},
+		{
+			name:      "count",
+			rrule:     "FREQ=DAILY;COUNT=3",
+			firstDate: "2026-01-05", from: "2026-01-01", to: "2026-02-01",
+			want: []string{"2026-01-05", "2026-01-06", "2026-01-07"},
+		},
+		{
+			name:      "count includes occurrences before from",
+			rrule:     "FREQ=DAILY;COUNT=3",
+			firstDate: "2026-01-05", from: "2026-01-06", to: "2026-02-01",
+			want: []string{"2026-01-06", "2026-01-07"},
+		},
+		{
+			name:      "until includes the whole day",
+			rrule:     "FREQ=DAILY;UNTIL=20260108",
+			firstDate: "2026-01-05", from: "2026-01-01", to: "2026-02-01",
+			want: []string{"2026-01-05", "2026-01-06", "2026-01-07", "2026-01-08"},
+		},
+		{
+			name:      "weekdays",
+			rrule:     "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+			firstDate: "2026-01-05", from: "2026-01-05", to: "2026-01-19",
+			want: []string{"2026-01-05", "2026-01-07", "2026-01-09", "2026-01-12", "2026-01-14", "2026-01-16"},
+		},
+		{
+			name:      "weekly on the first day's weekday",
+			rrule:     "FREQ=WEEKLY",
+			firstDate: "2026-01-07", from: "2026-01-01", to: "2026-01-29",
+			want: []string{"2026-01-07", "2026-01-14", "2026-01-21", "2026-01-28"},
+		},
+		{
+			name:      "fortnightly from mid-week",
+			rrule:     "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+			firstDate: "2026-01-07", from: "2026-01-01", to: "2026-02-01",
+			want: []string{"2026-01-08", "2026-01-19", "2026-01-22"},
+		},
+		{
+			name:      "second tuesday",
+			rrule:     "FREQ=MONTHLY;BYDAY=2TU",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2026-04-01",
+			want: []string{"2026-01-13", "2026-02-10", "2026-03-10"},
+		},
+		{
+			name:      "last friday",
+			rrule:     "FREQ=MONTHLY;BYDAY=-1FR",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2026-04-01",
+			want: []string{"2026-01-30", "2026-02-27", "2026-03-27"},
+		},
+		{
+			name:      "last day of the month",
+			rrule:     "FREQ=MONTHLY;BYMONTHDAY=-1",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2026-04-01",
+			want: []string{"2026-01-31", "2026-02-28", "2026-03-31"},
+		},
+		{
+			name:      "monthly on the 31st skips shorter months",
+			rrule:     "FREQ=MONTHLY",
+			firstDate: "2026-01-31", from: "2026-01-01", to: "2026-06-01",
+			want: []string{"2026-01-31", "2026-03-31", "2026-05-31"},
+		},
+		{
+			name:      "every other month",
+			rrule:     "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=15",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2026-07-01",
+			want: []string{"2026-01-15", "2026-03-15", "2026-05-15"},
+		},
+		{
+			name:      "yearly",
+			rrule:     "FREQ=YEARLY",
+			firstDate: "2026-03-10", from: "2026-01-01", to: "2028-12-31",
+			want: []string{"2026-03-10", "2027-03-10", "2028-03-10"},
+		},
+		{
+			name:      "second sunday of may",
+			rrule:     "FREQ=YEARLY;BYMONTH=5;BYDAY=2SU",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2028-01-01",
+			want: []string{"2026-05-10", "2027-05-09"},
+		},
+		{
+			name:      "twentieth monday of the year",
+			rrule:     "FREQ=YEARLY;BYDAY=20MO",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2027-01-01",
+			want: []string{"2026-05-18"},
+		},
+		{
+			name:      "every monday of the year",
+			rrule:     "FREQ=YEARLY;BYDAY=MO",
+			firstDate: "2026-01-01", from: "2026-01-20", to: "2026-02-17",
+			want: []string{"2026-01-26", "2026-02-02", "2026-02-09", "2026-02-16"},
+		},
+		{
+			name:      "mondays and the last sunday of the year",
+			rrule:     "FREQ=YEARLY;BYDAY=-1SU,MO",
+			firstDate: "2026-01-01", from: "2026-12-20", to: "2027-01-01",
+			want: []string{"2026-12-21", "2026-12-27", "2026-12-28"},
+		},
+		{
+			name:      "first of every month",
+			rrule:     "FREQ=YEARLY;BYMONTHDAY=1",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2026-04-01",
+			want: []string{"2026-01-01", "2026-02-01", "2026-03-01"},
+		},
+		{
+			name:      "last sunday of the year",
+			rrule:     "FREQ=YEARLY;BYDAY=-1SU",
+			firstDate: "2026-01-01", from: "2026-01-01", to: "2027-01-01",
+			want: []string{"2026-12-27"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := startDates(t, test.rrule, test.firstDate, test.from, test.to)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v; want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v; want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBetweenUntilDate(t *testing.T) {
+	// Midnight in Dhaka is still the day before in UTC, so a plain UNTIL date
+	// has to be read as a Dhaka day.
+	schedule, err := NewSchedule("FREQ=DAILY;UNTIL=20260110", "2026-01-08", "00:00", "00:00", "Asia/Dhaka", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences := schedule.Between(schedule.First, schedule.First.AddDate(0, 0, 7))
+	if len(occurrences) != 3 || occurrences[2].Start.Format(time.DateOnly) != "2026-01-10" {
+		t.Errorf("got %+v; want visits from 8 to 10 January", occurrences)
+	}
+}
+
+func TestBetweenWindow(t *testing.T) {
+	schedule, err := NewSchedule("FREQ=DAILY", "2026-01-05", "09:00", "17:30", "Asia/Dhaka", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhaka := schedule.Location
+
+	occurrences := schedule.Between(time.Date(2026, time.January, 5, 0, 0, 0, 0, dhaka), time.Date(2026, time.January, 6, 0, 0, 0, 0, dhaka))
+	if len(occurrences) != 1 {
+		t.Fatalf("got %d occurrences; want 1", len(occurrences))
+	}
+	want := Occurrence{
+		Start: time.Date(2026, time.January, 5, 9, 0, 0, 0, dhaka),
+		End:   time.Date(2026, time.January, 5, 17, 30, 0, 0, dhaka),
+	}
+	if !occurrences[0].Start.Equal(want.Start) || !occurrences[0].End.Equal(want.End) {
+		t.Errorf("got %+v; want %+v", occurrences[0], want)
+	}
+	if !occurrences[0].Start.Equal(time.Date(2026, time.January, 5, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("09:00 in Dhaka is %v in UTC", occurrences[0].Start.UTC())
+	}
+
+	// A visit under way at from is still listed.
+	occurrences = schedule.Between(time.Date(2026, time.January, 5, 12, 0, 0, 0, dhaka), time.Date(2026, time.January, 6, 0, 0, 0, 0, dhaka))
+	if len(occurrences) != 1 {
+		t.Errorf("a visit under way was left out: %+v", occurrences)
+	}
+	// One that ended exactly at from is not.
+	occurrences = schedule.Between(time.Date(2026, time.January, 5, 17, 30, 0, 0, dhaka), time.Date(2026, time.January, 6, 0, 0, 0, 0, dhaka))
+	if len(occurrences) != 0 {
+		t.Errorf("a finished visit was listed: %+v", occurrences)
+	}
+}
+
+func TestBetweenOvernight(t *testing.T) {
+	schedule, err := NewSchedule("FREQ=DAILY", "2026-01-05", "22:00", "06:00", "Asia/Dhaka", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhaka := schedule.Location
+
+	occurrences := schedule.Between(time.Date(2026, time.January, 5, 0, 0, 0, 0, dhaka), time.Date(2026, time.January, 7, 0, 0, 0, 0, dhaka))
+	if len(occurrences) != 2 {
+		t.Fatalf("got %d occurrences; want 2", len(occurrences))
+	}
+	wantEnd := time.Date(2026, time.January, 6, 6, 0, 0, 0, dhaka)
+	if !occurrences[0].End.Equal(wantEnd) {
+		t.Errorf("an overnight visit ends at %v; want %v", occurrences[0].End, wantEnd)
+	}
+
+	// A night visit that started the evening before from still overlaps it.
+	occurrences = schedule.Between(time.Date(2026, time.January, 6, 3, 0, 0, 0, dhaka), time.Date(2026, time.January, 6, 4, 0, 0, 0, dhaka))
+	if len(occurrences) != 1 || !occurrences[0].Start.Equal(time.Date(2026, time.January, 5, 22, 0, 0, 0, dhaka)) {
+		t.Errorf("got %+v; want the visit from the evening before", occurrences)
+	}
+}
+
+func TestBetweenWholeDay(t *testing.T) {
+	schedule, err := NewSchedule("FREQ=DAILY", "2026-01-05", "00:00", "00:00", "Asia/Dhaka", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences := schedule.Between(schedule.First, schedule.First.AddDate(0, 0, 2))
+	if len(occurrences) != 2 {
+		t.Fatalf("got %d occurrences; want 2", len(occurrences))
+	}
+	if occurrences[0].End.Sub(occurrences[0].Start) != 24*time.Hour || !occurrences[0].End.Equal(occurrences[1].Start) {
+		t.Errorf("whole days do not run back to back: %+v", occurrences)
+	}
+}
+
+func TestBetweenDST(t *testing.T) {
+	// Clocks in London go forward on 29 March 2026 and back on 25 October.
+	schedule, err := NewSchedule("FREQ=DAILY", "2026-03-27", "09:00", "17:00", "Europe/London", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	london := schedule.Location
+
+	occurrences := schedule.Between(time.Date(2026, time.March, 27, 0, 0, 0, 0, london), time.Date(2026, time.April, 1, 0, 0, 0, 0, london))
+	if len(occurrences) != 5 {
+		t.Fatalf("got %d occurrences; want 5", len(occurrences))
+	}
+	for _, occurrence := range occurrences {
+		local := occurrence.Start.In(london)
+		if local.Hour() != 9 || local.Minute() != 0 {
+			t.Errorf("visit starts at %v local time; want 09:00", local)
+		}
+		if occurrence.End.Sub(occurrence.Start) != 8*time.Hour {
+			t.Errorf("visit on %v lasts %v; want 8h", local, occurrence.End.Sub(occurrence.Start))
+		}
+	}
+	if occurrences[0].Start.UTC().Hour() != 9 || occurrences[4].Start.UTC().Hour() != 8 {
+		t.Errorf("UTC start hours are %d and %d; want 9 and 8", occurrences[0].Start.UTC().Hour(), occurrences[4].Start.UTC().Hour())
+	}
+
+	// Weekly intervals count calendar weeks, not 168-hour blocks, across the
+	// change.
+	schedule, err = NewSchedule("FREQ=WEEKLY;INTERVAL=2", "2026-03-16", "09:00", "10:00", "Europe/London", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences = schedule.Between(time.Date(2026, time.March, 1, 0, 0, 0, 0, london), time.Date(2026, time.November, 1, 0, 0, 0, 0, london))
+	for _, occurrence := range occurrences {
+		local := occurrence.Start.In(london)
+		if local.Weekday() != time.Monday || local.Hour() != 9 {
+			t.Errorf("visit starts on %v; want Mondays at 09:00", local)
+		}
+	}
+	if len(occurrences) != 17 {
+		t.Errorf("got %d fortnightly visits; want 17", len(occurrences))
+	}
+}
+
+func TestBetweenEnd(t *testing.T) {
+	end := time.Date(2026, time.January, 7, 12, 0, 0, 0, time.UTC)
+	schedule, err := NewSchedule("FREQ=DAILY", "2026-01-05", "09:00", "17:00", "UTC", end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences := schedule.Between(schedule.First, schedule.First.AddDate(0, 1, 0))
+	if len(occurrences) != 3 {
+		t.Fatalf("got %d occurrences; want 3", len(occurrences))
+	}
+	if !occurrences[2].End.Equal(end) {
+		t.Errorf("the last visit ends at %v; want it cut short at %v", occurrences[2].End, end)
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		rrule, firstDate, startTime, endTime, timezone string
+	}{
+		{"FREQ=SECONDLY", "2026-01-05", "09:00", "17:00", "UTC"},
+		{"FREQ=DAILY", "05/01/2026", "09:00", "17:00", "UTC"},
+		{"FREQ=DAILY", "2026-01-05", "9", "17:00", "UTC"},
+		{"FREQ=DAILY", "2026-01-05", "09:00", "17:00", "Mars/Olympus"},
+	}
+	for _, test := range tests {
+		if _, err := NewSchedule(test.rrule, test.firstDate, test.startTime, test.endTime, test.timezone, time.Time{}); err == nil {
+			t.Errorf("NewSchedule(%+v) did not fail", test)
+		}
+	}
+}
+
+func TestOverlapping(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, time.January, 5, hour, 0, 0, 0, time.UTC) }
+	a := []Occurrence{{at(9), at(12)}, {at(14), at(16)}, {at(20), at(22)}}
+	b := []Occurrence{{at(8), at(9)}, {at(11), at(15)}, {at(16), at(17)}, {at(21), at(23)}}
+
+	pairs := Overlapping(a, b)
+	want := [][2]Occurrence{
+		{a[0], b[1]},
+		{a[1], b[1]},
+		{a[2], b[3]},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("got %d pairs; want %d: %+v", len(pairs), len(want), pairs)
+	}
+	for i := range want {
+		if pairs[i] != want[i] {
+			t.Errorf("pair %d = %+v; want %+v", i, pairs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"fmt"
+	"jotno-server/documents"
+	"jotno-server/models"
+	"jotno-server/payments"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm/clause"
+)
+
+// GetBillDocument serves the invoice or receipt for a bill to either party.
+// A document is rendered and stored the first time it is asked for, and the
+// stored copy is served from then on.
+func GetBillDocument(ctx iris.Context) {
+	var query BillDocumentQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+	if query.Language == "" {
+		query.Language = documents.LanguageEnglish
+	}
+	if query.Language == documents.LanguageBangla {
+		utils.CreateError(iris.StatusNotImplemented, "Not Implemented", "Documents are not available in Bangla yet.", ctx)
+		return
+	}
+
+	bill, booking, _ := getBillForParty(query.BillID, ctx)
+	if bill == nil {
+		return
+	}
+	// A user marking a cash bill paid is not proof of payment; a receipt
+	// waits until the specialist has confirmed it too.
+	if query.Kind == documents.KindReceipt && !bill.Complete {
+		utils.CreateError(iris.StatusConflict, "Conflict", "A receipt is only available once the payment is confirmed.", ctx)
+		return
+	}
+
+	var document models.Document
+	documentExists := storage.DB.Where("bill_id = ? AND kind = ? AND language = ?", bill.ID, query.Kind, query.Language).Find(&document)
+	if documentExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	var pdf []byte
+	if documentExists.RowsAffected == 1 {
+		pdf, err = storage.DownloadFile(document.StorageKey)
+	} else {
+		document, pdf, err = createBillDocument(bill, booking, query.Kind, query.Language)
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	ctx.ContentType("application/pdf")
+	ctx.Header("Content-Disposition", `attachment; filename="`+document.Number+`-`+document.Kind+`.pdf"`)
+	ctx.Write(pdf)
+}
+
+func createBillDocument(bill *models.Bill, booking *models.Booking, kind string, language string) (models.Document, []byte, error) {
+	document := models.Document{BillID: bill.ID, Kind: kind, Language: language}
+
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil {
+		return document, nil, userExists.Error
+	}
+	var specialist models.Specialist
+	specialistExists := storage.DB.Where("id = ?", bill.SpecialistID).Find(&specialist)
+	if specialistExists.Error != nil {
+		return document, nil, specialistExists.Error
+	}
+
+	number, err := billDocumentNumber(bill.ID, kind)
+	if err != nil {
+		return document, nil, err
+	}
+	document.Number = number
+
+	doc := documents.BillDocument{
+		Kind:            kind,
+		Number:          number,
+		Language:        language,
+		IssuedAt:        time.Now(),
+		CustomerName:    user.FirstName + " " + user.LastName,
+		CustomerAddress: user.Address,
+		CustomerPhone:   user.CallingCode + user.PhoneNumber,
+		SpecialistName:  specialist.FirstName + " " + specialist.LastName,
+		SpecialistPhone: specialist.CallingCode + specialist.PhoneNumber,
+		JobType:         booking.JobType,
+		Frequency:       booking.Frequency,
+		PeriodStart:     bill.PeriodStart,
+		PeriodEnd:       bill.PeriodEnd,
+		Amount:          bill.Amount,
+		PlatformFee:     bill.PlatformFee,
+	}
+	if kind == documents.KindReceipt {
+		paidAt := bill.UpdatedAt
+		if bill.PaidAt != nil {
+			paidAt = *bill.PaidAt
+		}
+		doc.PaidAt = &paidAt
+		doc.PaymentMethod = billPaymentMethod(bill.ID)
+	}
+
+	pdf, err := documents.RenderBill(doc)
+	if err != nil {
+		return document, nil, err
+	}
+
+	document.StorageKey = fmt.Sprintf("documents/bills/%d/%s-%s.pdf", bill.ID, kind, language)
+	err = storage.UploadFile(document.StorageKey, pdf, "application/pdf")
+	if err != nil {
+		return document, nil, err
+	}
+
+	documentCreated := storage.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&document)
+	return document, pdf, documentCreated.Error
+}
+
+// billDocumentNumber gives every language of a bill's invoice or receipt the
+// same number, taking the next one from the invoice sequence the first time.
+func billDocumentNumber(billID uint, kind string) (string, error) {
+	var sibling models.Document
+	siblingExists := storage.DB.Where("bill_id = ? AND kind = ?", billID, kind).Limit(1).Find(&sibling)
+	if siblingExists.Error != nil {
+		return "", siblingExists.Error
+	}
+	if siblingExists.RowsAffected == 1 {
+		return sibling.Number, nil
+	}
+
+	var next int64
+	sequenceRead := storage.DB.Raw("SELECT nextval('invoice_number_seq')").Scan(&next)
+	if sequenceRead.Error != nil {
+		return "", sequenceRead.Error
+	}
+	prefix := "INV"
+	if kind == documents.KindReceipt {
+		prefix = "RCT"
+	}
+	return prefix + "-" + strconv.Itoa(time.Now().Year()) + "-" + fmt.Sprintf("%06d", next), nil
+}
+
+// billPaymentMethod reports how a bill was paid: the method of its successful
+// online payment, or cash when there was none.
+func billPaymentMethod(billID uint) string {
+	var attempt models.PaymentAttempt
+	attemptExists := storage.DB.Where("bill_id = ? AND status = ?", billID, payments.StatusSucceeded).Limit(1).Find(&attempt)
+	if attemptExists.Error != nil || attemptExists.RowsAffected == 0 {
+		return "cash"
+	}
+	return attempt.Method
+}
+
+type BillDocumentQuery struct {
+	BillID   uint   `url:"billId" validate:"required"`
+	Kind     string `url:"kind" validate:"required,oneof=invoice receipt"`
+	Language string `url:"lang" validate:"omitempty,oneof=en bn"`
+}
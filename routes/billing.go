@@ -0,0 +1,454 @@
+package routes
+
+import (
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/recurrence"
+	"jotno-server/storage"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// billingPeriod is the half-open span [Start, End) covered by one bill. Full
+// is the length of the period had it not been cut short by the end date or a
+// change of terms. Scheduled is the visit time the booking's occurrences call
+// for over the full period, and Billable the part of it inside [Start, End)
+// and outside pause windows that a specialist covered. BillableBy splits
+// Billable between the specialists who covered it.
+type billingPeriod struct {
+	Start      time.Time
+	End        time.Time
+	Full       time.Duration
+	Scheduled  time.Duration
+	Billable   time.Duration
+	BillableBy map[uint]time.Duration
+	Amount     money.Money
+}
+
+// Bills charge either a billing period or the fee for a late cancellation.
+const (
+	BillKindPeriod          = "period"
+	BillKindCancellationFee = "cancellationFee"
+)
+
+// bookingTerms are the amount and frequency a booking bills at from From on.
+type bookingTerms struct {
+	From      time.Time
+	Amount    money.Money
+	Frequency string
+}
+
+// RunBilling bills every active booking for each period that has started and
+// has not been billed yet. Bills are keyed by their period, so running it more
+// than once for the same period does nothing. It runs from the scheduler.
+func RunBilling(report *JobReport) error {
+	var activeBookings []models.Booking
+	activeBookingsExists := storage.DB.Where("status = ? AND overdue = false", BookingActive).Find(&activeBookings)
+	if activeBookingsExists.Error != nil {
+		return activeBookingsExists.Error
+	}
+
+	now := time.Now()
+	for _, booking := range activeBookings {
+		billBooking(booking, now, report)
+	}
+	return nil
+}
+
+func billBooking(booking models.Booking, now time.Time, report *JobReport) {
+	changes, err := acceptedBookingChanges(booking.ID)
+	if err != nil {
+		report.Fail(fmt.Errorf("booking %d: %w", booking.ID, err))
+		return
+	}
+	substitutions, err := bookingSubstitutions(booking.ID)
+	if err != nil {
+		report.Fail(fmt.Errorf("booking %d: %w", booking.ID, err))
+		return
+	}
+	periods, err := bookingPeriods(booking, changes, substitutions, now)
+	if err != nil {
+		report.Fail(fmt.Errorf("booking %d: %w", booking.ID, err))
+		return
+	}
+	// The same periods as they were billed in advance, before anyone
+	// dropped out.
+	unsubstituted, err := bookingPeriods(booking, changes, nil, now)
+	if err != nil {
+		report.Fail(fmt.Errorf("booking %d: %w", booking.ID, err))
+		return
+	}
+
+	var bills []models.Bill
+	billsExist := storage.DB.Where("booking_id = ?", booking.ID).Find(&bills)
+	if billsExist.Error != nil {
+		report.Fail(fmt.Errorf("booking %d: %w", booking.ID, billsExist.Error))
+		return
+	}
+
+	for i, period := range periods {
+		// A substitute covering visits in a period billed already is paid
+		// out of that bill, so the user is not billed twice.
+		billed := billsForPeriod(bills, period)
+		if len(billed) > 0 {
+			resplitErr := retry(3, time.Second, func() error {
+				return resplitPeriodBill(booking, billed, period, unsubstituted[i])
+			})
+			if resplitErr != nil {
+				report.Fail(fmt.Errorf("booking %d period %s: %w", booking.ID, period.Start.Format(time.DateOnly), resplitErr))
+			}
+			continue
+		}
+
+		// Periods with no visits left to make are not billed at all.
+		if period.Billable <= 0 {
+			continue
+		}
+		for _, bill := range periodBills(booking, period) {
+			billErr := retry(3, time.Second, func() error {
+				_, err := CreateBill(booking, bill)
+				return err
+			})
+			if billErr != nil {
+				report.Fail(fmt.Errorf("booking %d period %s: %w", booking.ID, period.Start.Format(time.DateOnly), billErr))
+				continue
+			}
+			report.Processed()
+		}
+	}
+}
+
+// periodBills splits the charge for a period between the specialists who
+// covered its visits, in proportion to the time each of them covered. The
+// last one takes any rounding remainder.
+func periodBills(booking models.Booking, period billingPeriod) []models.Bill {
+	var specialistIDs []uint
+	for specialistID := range period.BillableBy {
+		specialistIDs = append(specialistIDs, specialistID)
+	}
+	slices.Sort(specialistIDs)
+
+	amount := proratedAmount(period)
+	remaining := amount
+	var bills []models.Bill
+	for i, specialistID := range specialistIDs {
+		share := remaining
+		if i < len(specialistIDs)-1 {
+			share = amount.Scale(period.BillableBy[specialistID].Hours() / period.Billable.Hours())
+			remaining, _ = remaining.Sub(share)
+		}
+		if !share.IsPositive() {
+			continue
+		}
+		bills = append(bills, models.Bill{
+			BookingID:      booking.ID,
+			SpecialistID:   specialistID,
+			Kind:           BillKindPeriod,
+			Amount:         share,
+			PlatformFee:    commissionFor(booking.JobType, share),
+			RefundedAmount: money.Zero(share.Currency),
+			PeriodStart:    period.Start,
+			PeriodEnd:      period.End,
+			Prorated:       period.Billable < period.Scheduled,
+		})
+	}
+	return bills
+}
+
+// resplitPeriodBill shares the first bill of a period with the substitutes
+// who covered visits in it and have no bill of their own for the period.
+// Each is owed the part of the bill's earnings their time is of the time the
+// bill was charged for; the rest stays with the bill's specialist. Only the
+// change since the last re-split is posted, so it is safe to run again.
+func resplitPeriodBill(booking models.Booking, billed []models.Bill, period billingPeriod, unsubstituted billingPeriod) error {
+	base := billed[0]
+	charged := unsubstituted.Billable
+	ownBill := map[uint]bool{base.SpecialistID: true}
+	for _, bill := range billed[1:] {
+		charged -= period.BillableBy[bill.SpecialistID]
+		ownBill[bill.SpecialistID] = true
+	}
+	earnings, err := base.Amount.Sub(base.PlatformFee)
+	if err != nil {
+		return err
+	}
+
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		shares, err := billShares(tx, base.ID)
+		if err != nil {
+			return err
+		}
+		targets := make(map[uint]money.Money)
+		for specialistID := range shares {
+			targets[specialistID] = money.Zero(earnings.Currency)
+		}
+		for specialistID, covered := range period.BillableBy {
+			if ownBill[specialistID] || charged <= 0 {
+				continue
+			}
+			targets[specialistID] = earnings.Scale(min(covered.Hours()/charged.Hours(), 1))
+		}
+
+		specialistIDs := make([]uint, 0, len(targets))
+		for specialistID := range targets {
+			specialistIDs = append(specialistIDs, specialistID)
+		}
+		slices.Sort(specialistIDs)
+		for _, specialistID := range specialistIDs {
+			current, ok := shares[specialistID]
+			if !ok {
+				current = money.Zero(earnings.Currency)
+			}
+			change, err := targets[specialistID].Sub(current)
+			if err != nil {
+				return err
+			}
+			if change.IsZero() {
+				continue
+			}
+			err = postBillResplit(tx, &booking, &base, specialistID, change)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// billsForPeriod lists the bills already made for a period, oldest first.
+// Bills made before periods were recorded count for the period they were
+// created in.
+func billsForPeriod(bills []models.Bill, period billingPeriod) []models.Bill {
+	var billed []models.Bill
+	for _, bill := range bills {
+		if bill.Kind == BillKindCancellationFee {
+			continue
+		}
+		if !bill.PeriodStart.IsZero() {
+			if bill.PeriodStart.Equal(period.Start) {
+				billed = append(billed, bill)
+			}
+			continue
+		}
+		if !bill.CreatedAt.Before(period.Start) && bill.CreatedAt.Before(period.End) {
+			billed = append(billed, bill)
+		}
+	}
+	slices.SortFunc(billed, func(a, b models.Bill) int { return int(a.ID) - int(b.ID) })
+	return billed
+}
+
+// CreateBill records a bill for one period of a booking and posts it to the
+// ledger. A bill that already exists for the period and specialist is left
+// untouched.
+func CreateBill(booking models.Booking, bill models.Bill) (bool, error) {
+	created := false
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		billCreated := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&bill)
+		if billCreated.Error != nil || billCreated.RowsAffected == 0 {
+			return billCreated.Error
+		}
+		created = true
+		return postBillCreated(tx, &booking, &bill)
+	})
+	return created, err
+}
+
+// bookingPeriods lists the billing periods of a booking that have started by
+// now, from its start date up to its end date. Each accepted change of terms
+// starts a new run of periods from when it took effect. Each period is billed
+// for the booking's occurrences within it, less accepted pauses and visits
+// nobody covered after the specialist dropped out.
+func bookingPeriods(booking models.Booking, changes []models.BookingChangeRequest, substitutions []models.Substitution, now time.Time) ([]billingPeriod, error) {
+	location, err := time.LoadLocation(booking.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseBookingDate(booking.StartDate, location)
+	if err != nil {
+		return nil, err
+	}
+	// Periods run from midnight to midnight where the booking is, as its
+	// visits do, so monthly periods also keep to the local calendar.
+	start = start.In(location)
+
+	var end *time.Time
+	if booking.EndDate != "" {
+		parsedEnd, err := parseBookingEnd(booking.EndDate, location)
+		if err != nil {
+			return nil, err
+		}
+		end = &parsedEnd
+	}
+
+	schedule := bookingSchedule(booking, start, changes)
+	var periods []billingPeriod
+	for i, terms := range schedule {
+		terms.From = terms.From.In(location)
+		until := end
+		if i+1 < len(schedule) {
+			until = &schedule[i+1].From
+		}
+		for n := 0; ; n++ {
+			periodStart := addPeriods(terms.From, terms.Frequency, n)
+			if periodStart.After(now) || (until != nil && !periodStart.Before(*until)) {
+				break
+			}
+			periodEnd := addPeriods(terms.From, terms.Frequency, n+1)
+			period := billingPeriod{Start: periodStart, End: periodEnd, Full: periodEnd.Sub(periodStart), Amount: terms.Amount}
+			if until != nil && periodEnd.After(*until) {
+				period.End = *until
+			}
+			if end != nil && period.End.After(*end) {
+				period.End = *end
+			}
+			periods = append(periods, period)
+		}
+	}
+	if len(periods) == 0 {
+		return periods, nil
+	}
+
+	occurrenceSchedule, err := bookingOccurrenceSchedule(&booking)
+	if err != nil {
+		return nil, err
+	}
+	// The full period is scheduled as if the booking ran on past its end
+	// date, so a last period cut short is prorated like any other.
+	occurrenceSchedule.End = nil
+	last := periods[len(periods)-1]
+	occurrences := occurrenceSchedule.Between(periods[0].Start, last.Start.Add(last.Full))
+	next := 0
+	for i := range periods {
+		period := &periods[i]
+		for next < len(occurrences) && !occurrences[next].End.After(period.Start) {
+			next++
+		}
+		for _, occurrence := range occurrences[next:] {
+			if !occurrence.Start.Before(period.Start.Add(period.Full)) {
+				break
+			}
+			period.Scheduled += overlapWithin(occurrence, period.Start, period.Start.Add(period.Full))
+			from, to := maxTime(occurrence.Start, period.Start), minTime(occurrence.End, period.End)
+			specialistID := specialistAt(&booking, substitutions, occurrence.Start)
+			if !to.After(from) || specialistID == 0 {
+				continue
+			}
+			billable := to.Sub(from) - pausedWithin(changes, from, to)
+			if billable <= 0 {
+				continue
+			}
+			if period.BillableBy == nil {
+				period.BillableBy = make(map[uint]time.Duration)
+			}
+			period.Billable += billable
+			period.BillableBy[specialistID] += billable
+		}
+	}
+	return periods, nil
+}
+
+// overlapWithin is how much of the occurrence falls inside [start, end).
+func overlapWithin(occurrence recurrence.Occurrence, start time.Time, end time.Time) time.Duration {
+	from, to := maxTime(occurrence.Start, start), minTime(occurrence.End, end)
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// bookingSchedule lists the terms a booking has billed at since it started.
+// The booking holds the current terms, and each accepted change recorded the
+// terms it replaced, which rebuilds the ones before it.
+func bookingSchedule(booking models.Booking, start time.Time, changes []models.BookingChangeRequest) []bookingTerms {
+	schedule := []bookingTerms{{From: start, Amount: booking.Amount, Frequency: booking.Frequency}}
+	for _, change := range changes {
+		if change.Kind != BookingChangeTerms || change.EffectiveFrom == nil {
+			continue
+		}
+		last := &schedule[len(schedule)-1]
+		last.Amount, last.Frequency = change.PreviousAmount, change.PreviousFrequency
+		schedule = append(schedule, bookingTerms{From: *change.EffectiveFrom, Amount: change.Amount, Frequency: change.Frequency})
+	}
+	return schedule
+}
+
+// pausedWithin is how much of [start, end) falls inside accepted pauses.
+func pausedWithin(changes []models.BookingChangeRequest, start time.Time, end time.Time) time.Duration {
+	var paused time.Duration
+	for _, change := range changes {
+		if change.Kind != BookingChangePause || change.PauseStart == nil || change.PauseEnd == nil {
+			continue
+		}
+		from, to := *change.PauseStart, *change.PauseEnd
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			paused += to.Sub(from)
+		}
+	}
+	return paused
+}
+
+// addPeriods moves n billing periods on from the anchor. Monthly periods keep
+// the anchor's day of month, clamped to the length of shorter months.
+func addPeriods(anchor time.Time, frequency string, n int) time.Time {
+	if frequency == "daily" {
+		return anchor.AddDate(0, 0, n)
+	}
+
+	year, month, day := anchor.Date()
+	firstOfMonth := time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, anchor.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	hour, min, sec := anchor.Clock()
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, hour, min, sec, anchor.Nanosecond(), anchor.Location())
+}
+
+// parseBookingEnd returns the moment a booking stops. A plain calendar date
+// counts as the last day of service, so the booking runs until the next
+// midnight in the given location.
+func parseBookingEnd(date string, location *time.Location) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, date)
+	if err == nil {
+		return parsed, nil
+	}
+	parsed, err = time.ParseInLocation(time.DateOnly, date, location)
+	if err != nil {
+		return parsed, err
+	}
+	return parsed.AddDate(0, 0, 1), nil
+}
+
+// proratedAmount charges the period's amount in proportion to the scheduled
+// visit time that is billable.
+func proratedAmount(period billingPeriod) money.Money {
+	if period.Scheduled <= 0 || period.Billable >= period.Scheduled {
+		return period.Amount
+	}
+	return period.Amount.Scale(period.Billable.Hours() / period.Scheduled.Hours())
+}
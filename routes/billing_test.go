@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"jotno-server/models"
+	"testing"
+	"time"
+)
+
+func TestBookingPeriodsInBookingTimezone(t *testing.T) {
+	dhaka, err := time.LoadLocation("Asia/Dhaka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking := models.Booking{
+		SpecialistID: 4,
+		Frequency:    "daily",
+		Amount:       bdt(100000),
+		StartDate:    "2026-01-05",
+		EndDate:      "2026-01-07",
+		Recurrence:   "FREQ=DAILY",
+		StartTime:    "00:00",
+		EndTime:      "00:00",
+		Timezone:     "Asia/Dhaka",
+	}
+	pauseStart := time.Date(2026, time.January, 6, 0, 0, 0, 0, dhaka)
+	pauseEnd := pauseStart.AddDate(0, 0, 1)
+	changes := []models.BookingChangeRequest{{Kind: BookingChangePause, Status: BookingChangeAccepted, PauseStart: &pauseStart, PauseEnd: &pauseEnd}}
+
+	periods, err := bookingPeriods(booking, changes, nil, time.Date(2026, time.February, 1, 0, 0, 0, 0, dhaka))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(periods) != 3 {
+		t.Fatalf("got %d periods; want 3", len(periods))
+	}
+	for i, period := range periods {
+		wantStart := time.Date(2026, time.January, 5+i, 0, 0, 0, 0, dhaka)
+		if !period.Start.Equal(wantStart) || !period.End.Equal(wantStart.AddDate(0, 0, 1)) {
+			t.Errorf("period %d runs %v to %v; want the day from %v", i, period.Start, period.End, wantStart)
+		}
+		// Each whole-day visit falls in one period, not across two.
+		if period.Scheduled != 24*time.Hour {
+			t.Errorf("period %d has %v scheduled; want 24h", i, period.Scheduled)
+		}
+	}
+
+	// The one-day pause takes out exactly one day's bill.
+	for i, want := range []int64{100000, 0, 100000} {
+		if amount := proratedAmount(periods[i]); amount.Minor != want {
+			t.Errorf("period %d is billed %d; want %d", i, amount.Minor, want)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package routes
 
 import (
-	"encoding/json"
-	"fmt"
 	"jotno-server/models"
+	"jotno-server/money"
 	"jotno-server/storage"
 	"jotno-server/utils"
-	"strconv"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
 )
 
 func CreateBooking(ctx iris.Context) {
@@ -19,67 +20,63 @@ func CreateBooking(ctx iris.Context) {
 		utils.ValidationError(err, ctx)
 		return
 	}
+	if !bookingInput.Amount.IsPositive() {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "A booking needs an amount.", ctx)
+		return
+	}
+	_, startErr := parseBookingDate(bookingInput.StartDate, time.UTC)
+	if startErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid startDate.", ctx)
+		return
+	}
+	scheduleErr := validateBookingSchedule(bookingInput.BookingScheduleInput)
+	if scheduleErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", scheduleErr.Error(), ctx)
+		return
+	}
+
+	now := time.Now()
 	booking := models.Booking{
-		UserID:       bookingInput.UserID,
+		UserID:       jwt.Get(ctx).(*utils.AccessToken).ID,
 		SpecialistID: bookingInput.SpecialistID,
 		JobType:      bookingInput.JobType,
 		Active:       false,
-		Status:       "pending",
+		Status:       BookingRequested,
 		Frequency:    bookingInput.Frequency,
 		Amount:       bookingInput.Amount,
-		Currency:     bookingInput.Currency,
 		Overdue:      false,
 		StartDate:    bookingInput.StartDate,
 		EndDate:      bookingInput.EndDate,
+		RequestedAt:  &now,
 	}
-	storage.DB.Create(&booking)
-	ctx.JSON(booking)
-}
-
-func CreateBill(ID uint, amount int32, currency string, userID uint) {
-	var bill models.Bill
-	billExists := storage.DB.Where("booking_id = ?", strconv.FormatUint(uint64(ID), 10)).Order("created_at DESC").First(&bill)
-	if billExists.Error != nil {
-		payment := models.Bill{
-			BookingID: ID,
-			Paid:      false,
-			Received:  false,
-			Complete:  false,
-			Amount:    amount,
-			Currency:  currency,
-		}
-		fmt.Println("first")
-		storage.DB.Create(&payment)
+	applyBookingSchedule(&booking, bookingInput.BookingScheduleInput)
+	if !checkSpecialistAvailable(&booking, ctx) {
 		return
 	}
-	if bill.Complete {
-		payment := models.Bill{
-			BookingID: ID,
-			Paid:      false,
-			Received:  false,
-			Complete:  false,
-			Amount:    amount,
-			Currency:  currency,
+
+	bookingCreated := storage.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&booking).Error
+		if err != nil {
+			return err
 		}
-		fmt.Println("second")
-		storage.DB.Create(&payment)
+		return tx.Create(&models.BookingHistory{
+			BookingID: booking.ID,
+			ToStatus:  BookingRequested,
+			ActorID:   booking.UserID,
+			ActorRole: utils.RoleUser,
+		}).Error
+	})
+	if bookingCreated != nil {
+		utils.InternalServerError(ctx)
 		return
 	}
-	var user models.User
-	userExists := storage.DB.Where("id = ?", userID).First(&user)
-	if userExists.Error == nil && userExists.RowsAffected == 1 {
-		var tokens []string
-		if user.PushTokens != nil {
-			unmarshalErr := json.Unmarshal(user.PushTokens, &tokens)
-			if unmarshalErr == nil {
-				for i := 0; i < len(tokens); i++ {
-					SendNotification("exp://10.0.0.240:8081/--/tabs/bookingScreen/", tokens[i], "Your specialist is waiting.", "Finish paying you specialist for uninterupted service.")
-				}
-			}
-		}
-	}
+
+	notifyBookingParty(&booking, utils.RoleUser, "New booking request", "You have a new booking request.")
+	ctx.JSON(booking)
 }
 
+// GetBookingByUserID is the booking list older app versions read, grouped
+// under active, pending and completed. Newer versions use GetBookings.
 func GetBookingByUserID(ctx iris.Context) {
 	id := ctx.URLParam("id")
 	response := map[string][]models.Booking{}
@@ -100,7 +97,7 @@ func GetBookingByUserID(ctx iris.Context) {
 	}
 
 	var pending []models.Booking
-	pendingExists := storage.DB.Where("user_id = ? AND status = pending", id).Order("created_at DESC").Find(&pending)
+	pendingExists := storage.DB.Where("user_id = ? AND status IN ?", id, []string{BookingRequested, BookingAccepted}).Order("created_at DESC").Find(&pending)
 	if pendingExists.Error != nil {
 		response["pending"] = nil
 	} else {
@@ -108,7 +105,7 @@ func GetBookingByUserID(ctx iris.Context) {
 	}
 
 	var completed []models.Booking
-	completedExists := storage.DB.Where("user_id = ? AND status = completed", id).Order("created_at DESC").Find(&completed)
+	completedExists := storage.DB.Where("user_id = ? AND status = ?", id, BookingCompleted).Order("created_at DESC").Find(&completed)
 	if completedExists.Error != nil {
 		response["completed"] = nil
 	} else {
@@ -118,17 +115,78 @@ func GetBookingByUserID(ctx iris.Context) {
 	ctx.JSON(response)
 }
 
+// CancelBooking moves a booking to cancelled and keeps the row, together with
+// the reason, for both parties' records. A user cancelling late is billed the
+// fee from GetCancellationQuote, which they must confirm by sending it back.
+// Specialists who cancel often are flagged.
 func CancelBooking(ctx iris.Context) {
-	id := ctx.URLParam("bookingID")
+	bookingID, parseErr := ctx.URLParamInt("bookingID")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid bookingID.", ctx)
+		return
+	}
 
-	bookingDeleted := storage.DB.Delete(&models.Booking{}, id)
-	if bookingDeleted.Error != nil {
-		utils.CreateError(iris.StatusInternalServerError, "Error", bookingDeleted.Error.Error(), ctx)
+	var req CancelBookingInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
 		return
 	}
+
+	booking, role := getBookingForParty(uint(bookingID), ctx)
+	if booking == nil {
+		return
+	}
+
+	now := time.Now()
+	quote, err := cancellationQuote(booking, role, now)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if quote.Fee.IsPositive() && (req.ExpectedFee == nil || *req.ExpectedFee != quote.Fee.Minor) {
+		ctx.StopWithProblem(
+			iris.StatusConflict,
+			iris.NewProblem().Title("Conflict").Detail("Cancelling now carries a fee, which must be confirmed.").Key("quote", quote))
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitionBooking(tx, booking, BookingCancelled, claims.ID, role, req.Reason)
+		if err != nil {
+			return err
+		}
+		if quote.Fee.IsPositive() {
+			return createCancellationFee(tx, booking, quote)
+		}
+		if role == utils.RoleSpecialist {
+			return flagRepeatCanceller(tx, booking.SpecialistID, now)
+		}
+		return nil
+	})
+	if !handleBookingTransitionError(transitionErr, ctx) {
+		return
+	}
+
+	body := "Your booking was cancelled: " + req.Reason
+	if quote.Fee.IsPositive() {
+		body += " " + describeCancellationFee(quote)
+	}
+	notifyBookingParty(booking, role, "Booking cancelled", body)
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+// parseBookingDate accepts booking dates sent either as full timestamps or as
+// plain calendar dates, which start at midnight in the given location.
+func parseBookingDate(date string, location *time.Location) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, date)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation(time.DateOnly, date, location)
+}
+
 func GetPendingPaymentsByBookingID(ctx iris.Context) {
 	id := ctx.URLParam("bookingId")
 
@@ -143,12 +201,16 @@ func GetPendingPaymentsByBookingID(ctx iris.Context) {
 }
 
 type CreateBookingInput struct {
-	UserID       uint   `json:"userID" validate:"required"`
-	SpecialistID uint   `json:"specialistID" validate:"required"`
-	JobType      string `json:"jobType" validate:"required,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
-	Frequency    string `json:"frequency" validate:"required,oneof=monthly daily"`
-	Amount       int32  `json:"amount" validate:"required"`
-	Currency     string `json:"currency" validate:"required"`
-	StartDate    string `json:"startDate" validate:"required"`
-	EndDate      string `json:"endDate"`
+	BookingScheduleInput
+	SpecialistID uint        `json:"specialistID" validate:"required"`
+	JobType      string      `json:"jobType" validate:"required,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
+	Frequency    string      `json:"frequency" validate:"required,oneof=monthly daily"`
+	Amount       money.Money `json:"amount"`
+	StartDate    string      `json:"startDate" validate:"required"`
+	EndDate      string      `json:"endDate"`
+}
+
+type CancelBookingInput struct {
+	Reason      string `json:"reason" validate:"required,max=512"`
+	ExpectedFee *int64 `json:"expectedFee"`
 }
@@ -0,0 +1,533 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BookingChangeReschedule = "reschedule"
+	BookingChangePause      = "pause"
+	BookingChangeTerms      = "terms"
+)
+
+const (
+	BookingChangePending   = "pending"
+	BookingChangeAccepted  = "accepted"
+	BookingChangeDeclined  = "declined"
+	BookingChangeWithdrawn = "withdrawn"
+)
+
+// pauseReason marks pauses made for an accepted pause window, so only those
+// are lifted automatically when the window ends.
+const pauseReason = "Paused for an agreed break."
+
+// Bookings in these statuses can still be changed.
+var changeableBookingStatuses = []string{BookingAccepted, BookingActive, BookingPaused}
+
+var (
+	errBookingStarted        = errors.New("the booking has already started, so its start date cannot move")
+	errInvalidBookingDates   = errors.New("the booking dates are not valid")
+	errPauseOverlaps         = errors.New("the pause overlaps one already agreed")
+	errBookingChangeAnswered = errors.New("this change has already been answered")
+)
+
+// RequestBookingChange proposes a new start or end date, a pause window, or a
+// new amount or frequency. Nothing changes until the other party accepts.
+func RequestBookingChange(ctx iris.Context) {
+	var req BookingChangeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, role := getBookingForParty(req.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+	if !slices.Contains(changeableBookingStatuses, booking.Status) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "The booking cannot be changed in its current state.", ctx)
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	change := models.BookingChangeRequest{
+		BookingID:       booking.ID,
+		Kind:            req.Kind,
+		Status:          BookingChangePending,
+		RequestedByID:   claims.ID,
+		RequestedByRole: role,
+		Reason:          req.Reason,
+	}
+
+	switch req.Kind {
+	case BookingChangeReschedule:
+		if req.StartDate == "" && req.EndDate == "" {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "A reschedule needs a new startDate or endDate.", ctx)
+			return
+		}
+		change.StartDate = req.StartDate
+		change.EndDate = req.EndDate
+	case BookingChangePause:
+		location, err := time.LoadLocation(booking.Timezone)
+		if err != nil {
+			utils.InternalServerError(ctx)
+			return
+		}
+		pauseStart, startErr := parseBookingDate(req.PauseStart, location)
+		pauseEnd, endErr := parseBookingEnd(req.PauseEnd, location)
+		if startErr != nil || endErr != nil || !pauseEnd.After(pauseStart) {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "A pause needs a pauseStart before its pauseEnd.", ctx)
+			return
+		}
+		year, month, day := time.Now().In(location).Date()
+		if pauseStart.Before(time.Date(year, month, day, 0, 0, 0, 0, location)) {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "A pause cannot start in the past.", ctx)
+			return
+		}
+		change.PauseStart = &pauseStart
+		change.PauseEnd = &pauseEnd
+	case BookingChangeTerms:
+		change.Amount = booking.Amount
+		change.Frequency = booking.Frequency
+		if req.Amount != nil {
+			if req.Amount.Currency != booking.Amount.Currency || !req.Amount.IsPositive() {
+				utils.CreateError(iris.StatusBadRequest, "Bad Request", "The amount must be positive and in "+booking.Amount.Currency+".", ctx)
+				return
+			}
+			change.Amount = *req.Amount
+		}
+		if req.Frequency != "" {
+			change.Frequency = req.Frequency
+		}
+		if change.Amount == booking.Amount && change.Frequency == booking.Frequency {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "A change of terms needs a new amount or frequency.", ctx)
+			return
+		}
+	}
+
+	if err := validateBookingChange(booking, &change); err != nil {
+		utils.CreateError(iris.StatusConflict, "Conflict", err.Error(), ctx)
+		return
+	}
+
+	var pending int64
+	pendingQuery := storage.DB.Model(&models.BookingChangeRequest{}).
+		Where("booking_id = ? AND status = ?", booking.ID, BookingChangePending).
+		Count(&pending)
+	if pendingQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if pending > 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This booking already has a change waiting for an answer.", ctx)
+		return
+	}
+
+	changeCreated := storage.DB.Create(&change)
+	if changeCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifyBookingParty(booking, role, "Booking change requested", describeBookingChange(&change)+" Please accept or decline it.")
+	ctx.JSON(change)
+}
+
+func GetBookingChanges(ctx iris.Context) {
+	bookingID, parseErr := ctx.URLParamInt("bookingId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid bookingId.", ctx)
+		return
+	}
+
+	booking, _ := getBookingForViewer(uint(bookingID), ctx)
+	if booking == nil {
+		return
+	}
+
+	var changes []models.BookingChangeRequest
+	changesExist := storage.DB.Where("booking_id = ?", booking.ID).Order("created_at DESC").Find(&changes)
+	if changesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(changes)
+}
+
+// AcceptBookingChange applies a change for the party who did not ask for it.
+// New terms apply from the booking's next period, so bills already made and
+// the period running now keep the old ones. Likewise a pause only takes time
+// off periods that have not been billed yet.
+func AcceptBookingChange(ctx iris.Context) {
+	change, booking, role := getBookingChangeForResponse(ctx, false)
+	if change == nil {
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	now := time.Now()
+	transactionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		bookingLocked := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", booking.ID).Find(booking)
+		if bookingLocked.Error != nil {
+			return bookingLocked.Error
+		}
+		if err := validateBookingChange(booking, change); err != nil {
+			return err
+		}
+
+		switch change.Kind {
+		case BookingChangeReschedule:
+			if change.StartDate != "" {
+				booking.StartDate = change.StartDate
+			}
+			if change.EndDate != "" {
+				booking.EndDate = change.EndDate
+			}
+			schedule, err := bookingOccurrenceSchedule(booking)
+			if err != nil {
+				return err
+			}
+			conflicts, err := specialistConflicts(booking.SpecialistID, schedule, now, booking.ID)
+			if err != nil {
+				return err
+			}
+			if len(conflicts) > 0 {
+				return errSpecialistUnavailable
+			}
+		case BookingChangeTerms:
+			effectiveFrom, err := nextBillingPeriodStart(tx, booking, now)
+			if err != nil {
+				return err
+			}
+			change.EffectiveFrom = effectiveFrom
+			change.PreviousAmount = booking.Amount
+			change.PreviousFrequency = booking.Frequency
+			booking.Amount = change.Amount
+			booking.Frequency = change.Frequency
+		}
+
+		bookingUpdated := tx.Select("start_date", "end_date", "amount_minor", "amount_currency", "frequency", "updated_at").Save(booking)
+		if bookingUpdated.Error != nil {
+			return bookingUpdated.Error
+		}
+
+		change.Status = BookingChangeAccepted
+		change.RespondedAt = &now
+		changeUpdated := tx.Model(change).Where("status = ?", BookingChangePending).Updates(map[string]interface{}{
+			"status":                   change.Status,
+			"responded_at":             change.RespondedAt,
+			"effective_from":           change.EffectiveFrom,
+			"previous_amount_minor":    change.PreviousAmount.Minor,
+			"previous_amount_currency": change.PreviousAmount.Currency,
+			"previous_frequency":       change.PreviousFrequency,
+		})
+		if changeUpdated.Error != nil {
+			return changeUpdated.Error
+		}
+		if changeUpdated.RowsAffected == 0 {
+			return errBookingChangeAnswered
+		}
+
+		history := models.BookingHistory{
+			BookingID:  booking.ID,
+			FromStatus: booking.Status,
+			ToStatus:   booking.Status,
+			ActorID:    claims.ID,
+			ActorRole:  role,
+			Reason:     "Change accepted: " + describeBookingChange(change),
+		}
+		historyCreated := tx.Create(&history)
+		if historyCreated.Error != nil {
+			return historyCreated.Error
+		}
+
+		if change.Kind == BookingChangePause && booking.Status == BookingActive && pauseCovers(change, now) {
+			return transitionBooking(tx, booking, BookingPaused, 0, ActorSystem, pauseReason)
+		}
+		return nil
+	})
+	if errors.Is(transactionErr, errBookingStarted) || errors.Is(transactionErr, errInvalidBookingDates) || errors.Is(transactionErr, errPauseOverlaps) ||
+		errors.Is(transactionErr, errBookingChangeAnswered) || errors.Is(transactionErr, errSpecialistUnavailable) {
+		utils.CreateError(iris.StatusConflict, "Conflict", transactionErr.Error(), ctx)
+		return
+	}
+	if !handleBookingTransitionError(transactionErr, ctx) {
+		return
+	}
+
+	notifyBookingParty(booking, role, "Booking change accepted", describeBookingChange(change))
+	ctx.JSON(change)
+}
+
+func DeclineBookingChange(ctx iris.Context) {
+	respondToBookingChange(ctx, BookingChangeDeclined)
+}
+
+func WithdrawBookingChange(ctx iris.Context) {
+	respondToBookingChange(ctx, BookingChangeWithdrawn)
+}
+
+// respondToBookingChange closes a pending change without applying it. Only
+// the other party may decline it and only the one who asked may withdraw it.
+func respondToBookingChange(ctx iris.Context, to string) {
+	change, booking, role := getBookingChangeForResponse(ctx, to == BookingChangeWithdrawn)
+	if change == nil {
+		return
+	}
+
+	now := time.Now()
+	changeUpdated := storage.DB.Model(change).
+		Where("status = ?", BookingChangePending).
+		Updates(map[string]interface{}{"status": to, "responded_at": &now})
+	if changeUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if changeUpdated.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This change has already been answered.", ctx)
+		return
+	}
+
+	if to == BookingChangeDeclined {
+		notifyBookingParty(booking, role, "Booking change declined", "Your requested change was declined.")
+	}
+	ctx.JSON(change)
+}
+
+// ApplyBookingPauses pauses active bookings whose agreed pause window has
+// begun and resumes those whose window has ended. It runs from the scheduler.
+func ApplyBookingPauses(report *JobReport) error {
+	now := time.Now()
+
+	var bookings []models.Booking
+	bookingsExist := storage.DB.
+		Where("status = ?", BookingActive).
+		Where(`EXISTS (SELECT 1 FROM booking_change_requests WHERE booking_change_requests.booking_id = bookings.id
+		AND kind = ? AND status = ? AND pause_start <= ? AND pause_end > ? AND deleted_at IS NULL)`,
+			BookingChangePause, BookingChangeAccepted, now, now).
+		Find(&bookings)
+	if bookingsExist.Error != nil {
+		return bookingsExist.Error
+	}
+	for i := range bookings {
+		transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+			return transitionBooking(tx, &bookings[i], BookingPaused, 0, ActorSystem, pauseReason)
+		})
+		if transitionErr != nil {
+			report.Fail(fmt.Errorf("booking %d pause: %w", bookings[i].ID, transitionErr))
+			continue
+		}
+		report.Processed()
+		notifyBookingParty(&bookings[i], ActorSystem, "Booking paused", "Your agreed break has started.")
+	}
+
+	var paused []models.Booking
+	pausedExist := storage.DB.
+		Where("status = ?", BookingPaused).
+		Where(`NOT EXISTS (SELECT 1 FROM booking_change_requests WHERE booking_change_requests.booking_id = bookings.id
+		AND kind = ? AND status = ? AND pause_start <= ? AND pause_end > ? AND deleted_at IS NULL)`,
+			BookingChangePause, BookingChangeAccepted, now, now).
+		Find(&paused)
+	if pausedExist.Error != nil {
+		return pausedExist.Error
+	}
+	for i := range paused {
+		resumed := false
+		transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+			lastChange, err := lastStatusChange(tx, paused[i].ID)
+			if err != nil {
+				return err
+			}
+			if lastChange.ActorRole != ActorSystem || lastChange.Reason != pauseReason {
+				return nil
+			}
+			resumed = true
+			return transitionBooking(tx, &paused[i], BookingActive, 0, ActorSystem, "")
+		})
+		if transitionErr != nil {
+			report.Fail(fmt.Errorf("booking %d resume: %w", paused[i].ID, transitionErr))
+			continue
+		}
+		if resumed {
+			report.Processed()
+			notifyBookingParty(&paused[i], ActorSystem, "Booking resumed", "Your booking is active again.")
+		}
+	}
+	return nil
+}
+
+// acceptedBookingChanges loads the pauses and changes of terms that shape a
+// booking's billing, in the order they took effect. The times dunning
+// suspended the booking follow as pauses.
+func acceptedBookingChanges(bookingID uint) ([]models.BookingChangeRequest, error) {
+	var changes []models.BookingChangeRequest
+	changesExist := storage.DB.
+		Where("booking_id = ? AND status = ? AND kind IN ?", bookingID, BookingChangeAccepted, []string{BookingChangePause, BookingChangeTerms}).
+		Order("effective_from, id").
+		Find(&changes)
+	if changesExist.Error != nil {
+		return nil, changesExist.Error
+	}
+
+	suspensions, err := overdueSuspensions(bookingID)
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, suspensions...), nil
+}
+
+// nextBillingPeriodStart is where new terms take over: the end of the period
+// running now. A booking that has not started yet has no periods to keep, so
+// the terms change straight away and it returns nil.
+func nextBillingPeriodStart(tx *gorm.DB, booking *models.Booking, now time.Time) (*time.Time, error) {
+	var changes []models.BookingChangeRequest
+	changesExist := tx.
+		Where("booking_id = ? AND status = ? AND kind IN ?", booking.ID, BookingChangeAccepted, []string{BookingChangePause, BookingChangeTerms}).
+		Order("effective_from, id").
+		Find(&changes)
+	if changesExist.Error != nil {
+		return nil, changesExist.Error
+	}
+
+	periods, err := bookingPeriods(*booking, changes, nil, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	current := periods[len(periods)-1]
+	next := current.Start.Add(current.Full)
+	return &next, nil
+}
+
+// validateBookingChange checks a change against the booking as it is now,
+// both when it is asked for and again when it is accepted.
+func validateBookingChange(booking *models.Booking, change *models.BookingChangeRequest) error {
+	switch change.Kind {
+	case BookingChangeReschedule:
+		if change.StartDate != "" && bookingStarted(booking, time.Now()) {
+			return errBookingStarted
+		}
+		startDate := booking.StartDate
+		if change.StartDate != "" {
+			startDate = change.StartDate
+		}
+		location, err := time.LoadLocation(booking.Timezone)
+		if err != nil {
+			return err
+		}
+		start, err := parseBookingDate(startDate, location)
+		if err != nil {
+			return errInvalidBookingDates
+		}
+		if change.EndDate != "" {
+			end, err := parseBookingEnd(change.EndDate, location)
+			if err != nil || !end.After(start) || end.Before(time.Now()) {
+				return errInvalidBookingDates
+			}
+		}
+	case BookingChangePause:
+		var overlapping int64
+		overlapQuery := storage.DB.Model(&models.BookingChangeRequest{}).
+			Where("booking_id = ? AND kind = ? AND status = ? AND id <> ?", booking.ID, BookingChangePause, BookingChangeAccepted, change.ID).
+			Where("pause_start < ? AND pause_end > ?", change.PauseEnd, change.PauseStart).
+			Count(&overlapping)
+		if overlapQuery.Error != nil {
+			return overlapQuery.Error
+		}
+		if overlapping > 0 {
+			return errPauseOverlaps
+		}
+	}
+	return nil
+}
+
+func pauseCovers(change *models.BookingChangeRequest, now time.Time) bool {
+	return change.PauseStart != nil && change.PauseEnd != nil && !change.PauseStart.After(now) && change.PauseEnd.After(now)
+}
+
+// describeBookingChange says in a sentence what a change does.
+func describeBookingChange(change *models.BookingChangeRequest) string {
+	switch change.Kind {
+	case BookingChangeReschedule:
+		description := "Reschedule:"
+		if change.StartDate != "" {
+			description += " start on " + change.StartDate
+		}
+		if change.EndDate != "" {
+			description += " end on " + change.EndDate
+		}
+		return description + "."
+	case BookingChangePause:
+		// Pause windows end at the start of the day after the last one off.
+		return "Pause from " + change.PauseStart.Format(time.DateOnly) + " to " +
+			change.PauseEnd.AddDate(0, 0, -1).Format(time.DateOnly) + "."
+	}
+	return "New terms: " + change.Amount.String() + " " + change.Frequency + "."
+}
+
+// getBookingChangeForResponse loads a pending change the caller may answer:
+// the requester when withdrawing, otherwise the other party.
+func getBookingChangeForResponse(ctx iris.Context, asRequester bool) (*models.BookingChangeRequest, *models.Booking, string) {
+	var req BookingChangeResponseInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return nil, nil, ""
+	}
+
+	var change models.BookingChangeRequest
+	changeExists := storage.DB.Where("id = ?", req.ChangeRequestID).Find(&change)
+	if changeExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if changeExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil, ""
+	}
+
+	booking, role := getBookingForParty(change.BookingID, ctx)
+	if booking == nil {
+		return nil, nil, ""
+	}
+	if (role == change.RequestedByRole) != asRequester {
+		utils.CreateForbidden(ctx)
+		return nil, nil, ""
+	}
+	if change.Status != BookingChangePending {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This change has already been answered.", ctx)
+		return nil, nil, ""
+	}
+	return &change, booking, role
+}
+
+type BookingChangeInput struct {
+	BookingID  uint         `json:"bookingID" validate:"required"`
+	Kind       string       `json:"kind" validate:"required,oneof=reschedule pause terms"`
+	StartDate  string       `json:"startDate"`
+	EndDate    string       `json:"endDate"`
+	PauseStart string       `json:"pauseStart"`
+	PauseEnd   string       `json:"pauseEnd"`
+	Amount     *money.Money `json:"amount"`
+	Frequency  string       `json:"frequency" validate:"omitempty,oneof=monthly daily"`
+	Reason     string       `json:"reason" validate:"max=512"`
+}
+
+type BookingChangeResponseInput struct {
+	ChangeRequestID uint `json:"changeRequestID" validate:"required"`
+}
@@ -0,0 +1,260 @@
+package routes
+
+import (
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+const (
+	defaultBookingLimit = 20
+	maxBookingLimit     = 50
+)
+
+const (
+	BookingGroupActive  = "active"
+	BookingGroupPending = "pending"
+	BookingGroupPast    = "past"
+)
+
+// Booking statuses shown under each listing group.
+var bookingGroups = map[string][]string{
+	BookingGroupActive:  {BookingActive, BookingPaused},
+	BookingGroupPending: {BookingRequested, BookingAccepted},
+	BookingGroupPast:    {BookingCompleted, BookingCancelled, BookingDeclined},
+}
+
+// GetBookings lists the caller's bookings, as the user or the specialist,
+// grouped by state. Without a group it returns the first page of each one;
+// the cursor only applies when a single group is asked for.
+func GetBookings(ctx iris.Context) {
+	var filter BookingListFilter
+	err := ctx.ReadQuery(&filter)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultBookingLimit
+	}
+	if limit > maxBookingLimit {
+		limit = maxBookingLimit
+	}
+
+	groups := []string{BookingGroupActive, BookingGroupPending, BookingGroupPast}
+	if filter.Group != "" {
+		groups = []string{filter.Group}
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	response := iris.Map{}
+	for _, group := range groups {
+		query := storage.DB.Where("status IN ?", bookingGroups[group])
+		if claims.IsSpecialist() {
+			query = query.Where("specialist_id = ?", claims.ID)
+		} else {
+			query = query.Where("user_id = ?", claims.ID)
+		}
+		if filter.Group != "" && filter.Cursor > 0 {
+			query = query.Where("id < ?", filter.Cursor)
+		}
+
+		var bookings []models.Booking
+		bookingsExist := query.Order("id DESC").Limit(limit).Find(&bookings)
+		if bookingsExist.Error != nil {
+			utils.InternalServerError(ctx)
+			return
+		}
+
+		items, err := bookingListItems(bookings, claims.IsSpecialist())
+		if err != nil {
+			utils.InternalServerError(ctx)
+			return
+		}
+
+		var nextCursor uint
+		if len(bookings) == limit {
+			nextCursor = bookings[len(bookings)-1].ID
+		}
+		response[group] = BookingPage{Bookings: items, NextCursor: nextCursor}
+	}
+
+	ctx.JSON(response)
+}
+
+// GetBookingBills lists every bill of a booking, newest period first. Bills
+// of daily bookings carry the timesheet of their period, so each charge can
+// be checked against the visits made.
+func GetBookingBills(ctx iris.Context) {
+	var filter BookingBillsFilter
+	err := ctx.ReadQuery(&filter)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, _ := getBookingForViewer(filter.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultBookingLimit
+	}
+	if limit > maxBookingLimit {
+		limit = maxBookingLimit
+	}
+
+	query := storage.DB.Preload("Dispute").Where("booking_id = ?", booking.ID)
+	if filter.Cursor > 0 {
+		query = query.Where("id < ?", filter.Cursor)
+	}
+
+	var bills []models.Bill
+	billsExist := query.Order("id DESC").Limit(limit).Find(&bills)
+	if billsExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	items := []BillHistoryItem{}
+	for _, bill := range bills {
+		item := BillHistoryItem{Bill: bill}
+		if booking.Frequency == "daily" && !bill.PeriodStart.IsZero() {
+			item.Timesheet, err = buildTimesheet(booking, bill.PeriodStart, bill.PeriodEnd)
+			if err != nil {
+				utils.InternalServerError(ctx)
+				return
+			}
+		}
+		items = append(items, item)
+	}
+
+	var nextCursor uint
+	if len(bills) == limit {
+		nextCursor = bills[len(bills)-1].ID
+	}
+
+	ctx.JSON(iris.Map{
+		"bills":      items,
+		"nextCursor": nextCursor,
+	})
+}
+
+// bookingListItems adds the other party's profile and the open bill to each
+// booking, loading both in one query per page.
+func bookingListItems(bookings []models.Booking, asSpecialist bool) ([]BookingListItem, error) {
+	items := []BookingListItem{}
+	if len(bookings) == 0 {
+		return items, nil
+	}
+
+	var bookingIDs, counterpartIDs []uint
+	for _, booking := range bookings {
+		bookingIDs = append(bookingIDs, booking.ID)
+		if asSpecialist {
+			counterpartIDs = append(counterpartIDs, booking.UserID)
+		} else {
+			counterpartIDs = append(counterpartIDs, booking.SpecialistID)
+		}
+	}
+
+	var counterparts []BookingCounterpart
+	counterpartQuery := storage.DB.Table("specialists").
+		Select("id, first_name, last_name, avatar, city, calling_code, phone_number, stars, verified")
+	if asSpecialist {
+		counterpartQuery = storage.DB.Table("users").
+			Select("id, first_name, last_name, avatar, city, calling_code, phone_number")
+	}
+	counterpartsExist := counterpartQuery.Where("id IN ?", counterpartIDs).Scan(&counterparts)
+	if counterpartsExist.Error != nil {
+		return nil, counterpartsExist.Error
+	}
+	counterpartMap := make(map[uint]BookingCounterpart)
+	for _, counterpart := range counterparts {
+		counterpartMap[counterpart.ID] = counterpart
+	}
+
+	// The current bill is the latest one not yet complete.
+	var bills []models.Bill
+	billsExist := storage.DB.
+		Where("booking_id IN ? AND complete = false", bookingIDs).
+		Order("booking_id, period_start DESC").
+		Find(&bills)
+	if billsExist.Error != nil {
+		return nil, billsExist.Error
+	}
+	billMap := make(map[uint]*models.Bill)
+	for i := range bills {
+		if _, seen := billMap[bills[i].BookingID]; !seen {
+			billMap[bills[i].BookingID] = &bills[i]
+		}
+	}
+
+	for _, booking := range bookings {
+		counterpartID := booking.SpecialistID
+		counterpartRole := utils.RoleSpecialist
+		if asSpecialist {
+			counterpartID = booking.UserID
+			counterpartRole = utils.RoleUser
+		}
+		counterpart := counterpartMap[counterpartID]
+		counterpart.Role = counterpartRole
+
+		items = append(items, BookingListItem{
+			Booking:     booking,
+			Counterpart: counterpart,
+			CurrentBill: billMap[booking.ID],
+		})
+	}
+	return items, nil
+}
+
+type BookingListFilter struct {
+	Group  string `url:"group" validate:"omitempty,oneof=active pending past"`
+	Cursor uint   `url:"cursor"`
+	Limit  int    `url:"limit" validate:"gte=0"`
+}
+
+type BookingBillsFilter struct {
+	BookingID uint `url:"bookingId" validate:"required"`
+	Cursor    uint `url:"cursor"`
+	Limit     int  `url:"limit" validate:"gte=0"`
+}
+
+type BookingPage struct {
+	Bookings   []BookingListItem `json:"bookings"`
+	NextCursor uint              `json:"nextCursor"`
+}
+
+type BillHistoryItem struct {
+	models.Bill
+	Timesheet *Timesheet `json:"timesheet,omitempty"`
+}
+
+type BookingListItem struct {
+	models.Booking
+	Counterpart BookingCounterpart `json:"counterpart"`
+	CurrentBill *models.Bill       `json:"currentBill"`
+}
+
+// BookingCounterpart is the public profile of the other party to a booking.
+// Stars and verified only apply to specialists.
+type BookingCounterpart struct {
+	ID          uint   `json:"id"`
+	Role        string `json:"role" gorm:"-"`
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	Avatar      string `json:"avatar"`
+	City        string `json:"city"`
+	CallingCode string `json:"callingCode"`
+	PhoneNumber string `json:"phoneNumber"`
+	Stars       int    `json:"stars"`
+	Verified    bool   `json:"verified"`
+}
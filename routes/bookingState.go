@@ -0,0 +1,300 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	BookingRequested = "requested"
+	BookingAccepted  = "accepted"
+	BookingDeclined  = "declined"
+	BookingActive    = "active"
+	BookingPaused    = "paused"
+	BookingCompleted = "completed"
+	BookingCancelled = "cancelled"
+)
+
+// ActorSystem marks transitions made by scheduled tasks rather than a person.
+const ActorSystem = "system"
+
+// Statuses a booking may move to, keyed by its current status.
+var bookingTransitions = map[string][]string{
+	BookingRequested: {BookingAccepted, BookingDeclined, BookingCancelled},
+	BookingAccepted:  {BookingActive, BookingCancelled},
+	BookingActive:    {BookingPaused, BookingCompleted, BookingCancelled},
+	BookingPaused:    {BookingActive, BookingCompleted, BookingCancelled},
+}
+
+var errInvalidBookingTransition = errors.New("invalid booking transition")
+
+// transitionBooking moves a booking to a new status, stamps the matching
+// timestamp and records the change in the booking history.
+func transitionBooking(tx *gorm.DB, booking *models.Booking, to string, actorID uint, actorRole string, reason string) error {
+	from := booking.Status
+	if !slices.Contains(bookingTransitions[from], to) {
+		return errInvalidBookingTransition
+	}
+
+	now := time.Now()
+	booking.Status = to
+	booking.Active = to == BookingActive
+	switch to {
+	case BookingAccepted:
+		booking.AcceptedAt = &now
+		policy, err := offeringCancellationPolicy(tx, booking.SpecialistID, booking.JobType)
+		if err != nil {
+			return err
+		}
+		booking.CancellationPolicy = policy
+	case BookingDeclined:
+		booking.DeclinedAt = &now
+	case BookingActive:
+		booking.ActivatedAt = &now
+	case BookingPaused:
+		booking.PausedAt = &now
+	case BookingCompleted:
+		booking.CompletedAt = &now
+	case BookingCancelled:
+		booking.CancelledAt = &now
+		booking.CancellationReason = reason
+	}
+
+	bookingUpdated := tx.Select(
+		"status", "active", "accepted_at", "declined_at", "activated_at",
+		"paused_at", "completed_at", "cancelled_at", "cancellation_reason", "cancellation_policy", "updated_at",
+	).Save(booking)
+	if bookingUpdated.Error != nil {
+		return bookingUpdated.Error
+	}
+
+	history := models.BookingHistory{
+		BookingID:  booking.ID,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+		ActorRole:  actorRole,
+		Reason:     reason,
+	}
+	return tx.Create(&history).Error
+}
+
+func AcceptBooking(ctx iris.Context) {
+	respondToBooking(ctx, BookingAccepted)
+}
+
+func DeclineBooking(ctx iris.Context) {
+	respondToBooking(ctx, BookingDeclined)
+}
+
+// respondToBooking lets the booked specialist accept or decline a request.
+// Accepted bookings whose start date has arrived become active right away.
+func respondToBooking(ctx iris.Context, to string) {
+	var req BookingTransitionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, role := getBookingForParty(req.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+	if role != utils.RoleSpecialist {
+		utils.CreateForbidden(ctx)
+		return
+	}
+	if to == BookingAccepted && booking.Status == BookingRequested && !checkSpecialistAvailable(booking, ctx) {
+		return
+	}
+
+	transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitionBooking(tx, booking, to, booking.SpecialistID, role, req.Reason)
+		if err != nil || to != BookingAccepted || !bookingStarted(booking, time.Now()) {
+			return err
+		}
+		return transitionBooking(tx, booking, BookingActive, booking.SpecialistID, role, "")
+	})
+	if !handleBookingTransitionError(transitionErr, ctx) {
+		return
+	}
+
+	notifyBookingParty(booking, role, "Booking "+to, "Your booking request was "+to+".")
+	ctx.JSON(booking)
+}
+
+func CompleteBooking(ctx iris.Context) {
+	var req BookingTransitionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, role := getBookingForParty(req.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		return transitionBooking(tx, booking, BookingCompleted, claims.ID, role, req.Reason)
+	})
+	if !handleBookingTransitionError(transitionErr, ctx) {
+		return
+	}
+
+	notifyBookingParty(booking, role, "Booking completed", "Your booking was marked as completed.")
+	ctx.JSON(booking)
+}
+
+// ActivateBookings starts accepted bookings once their start date arrives.
+// It runs from the scheduler.
+func ActivateBookings(report *JobReport) error {
+	var bookings []models.Booking
+	bookingsExist := storage.DB.Where("status = ?", BookingAccepted).Find(&bookings)
+	if bookingsExist.Error != nil {
+		return bookingsExist.Error
+	}
+
+	now := time.Now()
+	for i := range bookings {
+		if !bookingStarted(&bookings[i], now) {
+			continue
+		}
+		transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+			return transitionBooking(tx, &bookings[i], BookingActive, 0, ActorSystem, "")
+		})
+		if transitionErr != nil {
+			report.Fail(fmt.Errorf("booking %d: %w", bookings[i].ID, transitionErr))
+			continue
+		}
+		report.Processed()
+		notifyBookingParty(&bookings[i], ActorSystem, "Booking started", "Your booking is now active.")
+	}
+	return nil
+}
+
+func bookingStarted(booking *models.Booking, now time.Time) bool {
+	location, err := time.LoadLocation(booking.Timezone)
+	if err != nil {
+		return false
+	}
+	startDate, err := parseBookingDate(booking.StartDate, location)
+	return err == nil && !startDate.After(now)
+}
+
+// lastStatusChange is the history entry that moved the booking into its
+// current status. Entries that only note something about the booking, with
+// the status unchanged, are passed over.
+func lastStatusChange(tx *gorm.DB, bookingID uint) (models.BookingHistory, error) {
+	var lastChange models.BookingHistory
+	lastChangeExists := tx.Where("booking_id = ? AND from_status <> to_status", bookingID).Order("created_at DESC").Limit(1).Find(&lastChange)
+	return lastChange, lastChangeExists.Error
+}
+
+// getBookingForParty loads a booking the caller is a party to and returns the
+// caller's role in it.
+func getBookingForParty(bookingID uint, ctx iris.Context) (*models.Booking, string) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", bookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, ""
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, ""
+	}
+
+	if claims.IsSpecialist() && booking.SpecialistID == claims.ID {
+		return &booking, utils.RoleSpecialist
+	}
+	if !claims.IsSpecialist() && booking.UserID == claims.ID {
+		return &booking, utils.RoleUser
+	}
+	utils.CreateForbidden(ctx)
+	return nil, ""
+}
+
+// getBookingForViewer is getBookingForParty that also lets in specialists on
+// either side of an approved substitution, so substitutes can see the visits
+// they cover and specialists who handed a booking over can still see what
+// they did on it.
+func getBookingForViewer(bookingID uint, ctx iris.Context) (*models.Booking, string) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !claims.IsSpecialist() {
+		return getBookingForParty(bookingID, ctx)
+	}
+
+	var substitutions int64
+	substitutionsExist := storage.DB.Model(&models.Substitution{}).
+		Where("booking_id = ? AND status = ?", bookingID, SubstitutionApproved).
+		Where("substitute_specialist_id = ? OR original_specialist_id = ?", claims.ID, claims.ID).
+		Count(&substitutions)
+	if substitutionsExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, ""
+	}
+	if substitutions == 0 {
+		return getBookingForParty(bookingID, ctx)
+	}
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", bookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, ""
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, ""
+	}
+	return &booking, utils.RoleSpecialist
+}
+
+// handleBookingTransitionError reports a failed transition and returns
+// whether the request may carry on.
+func handleBookingTransitionError(err error, ctx iris.Context) bool {
+	if errors.Is(err, errInvalidBookingTransition) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "The booking cannot make this change in its current state.", ctx)
+		return false
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return false
+	}
+	return true
+}
+
+// notifyBookingParty pushes a booking update to whoever did not make it.
+func notifyBookingParty(booking *models.Booking, actorRole string, title string, body string) {
+	path := bookingPath(booking.ID)
+	if actorRole == utils.RoleSpecialist {
+		notifyUser(booking.UserID, path, title, body)
+		return
+	}
+	if actorRole == utils.RoleUser {
+		notifySpecialist(booking.SpecialistID, path, title, body)
+		return
+	}
+	notifyUser(booking.UserID, path, title, body)
+	notifySpecialist(booking.SpecialistID, path, title, body)
+}
+
+type BookingTransitionInput struct {
+	BookingID uint   `json:"bookingID" validate:"required"`
+	Reason    string `json:"reason" validate:"max=512"`
+}
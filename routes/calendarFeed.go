@@ -0,0 +1,230 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"jotno-server/documents"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// CreateCalendarFeed issues a new secret feed URL for the caller, revoking
+// the one they had. Only a hash of the secret is stored, so the URL is shown
+// this once.
+func CreateCalendarFeed(ctx iris.Context) {
+	ownerID, ownerRole := calendarFeedOwner(ctx)
+
+	secretBytes := make([]byte, 32)
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	feed := models.CalendarFeed{OwnerID: ownerID, OwnerRole: ownerRole, TokenHash: hashFeedSecret(secret)}
+	feedCreated := storage.DB.Transaction(func(tx *gorm.DB) error {
+		err := revokeCalendarFeeds(tx, ownerID, ownerRole)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&feed).Error
+	})
+	if feedCreated != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"url":       os.Getenv("API_BASE_URL") + "/jotno/api/calendar/" + secret + ".ics",
+		"createdAt": feed.CreatedAt,
+	})
+}
+
+// GetCalendarFeed tells the caller whether they have a live feed and when a
+// calendar app last read it.
+func GetCalendarFeed(ctx iris.Context) {
+	ownerID, ownerRole := calendarFeedOwner(ctx)
+
+	var feed models.CalendarFeed
+	feedExists := storage.DB.Where("owner_id = ? AND owner_role = ? AND revoked_at IS NULL", ownerID, ownerRole).Find(&feed)
+	if feedExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if feedExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+	ctx.JSON(feed)
+}
+
+// RevokeCalendarFeed stops the caller's feed URL from working.
+func RevokeCalendarFeed(ctx iris.Context) {
+	ownerID, ownerRole := calendarFeedOwner(ctx)
+	err := revokeCalendarFeeds(storage.DB, ownerID, ownerRole)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// ServeCalendarFeed is the public .ics URL calendar apps subscribe to. The
+// secret in the path is the only credential, so unknown and revoked secrets
+// look the same.
+func ServeCalendarFeed(ctx iris.Context) {
+	secret := strings.TrimSuffix(ctx.Params().Get("secret"), ".ics")
+
+	var feed models.CalendarFeed
+	feedExists := storage.DB.Where("token_hash = ? AND revoked_at IS NULL", hashFeedSecret(secret)).Find(&feed)
+	if feedExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if feedExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	now := time.Now()
+	events, err := calendarEvents(feed.OwnerID, feed.OwnerRole, now)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	storage.DB.Model(&feed).UpdateColumn("last_fetched_at", now)
+
+	ctx.ContentType("text/calendar; charset=utf-8")
+	ctx.Header("Cache-Control", "private, max-age=900")
+	ctx.Write(documents.RenderCalendar(documents.Calendar{Name: "Jotno bookings", Events: events}, now))
+}
+
+// calendarEvents lists the upcoming visits of the owner's committed bookings
+// over the next CALENDAR_FEED_DAYS, leaving out paused ones. Specialists get
+// the visits they cover, substitutions included. Visits take place at the
+// user's address.
+func calendarEvents(ownerID uint, ownerRole string, now time.Time) ([]documents.CalendarEvent, error) {
+	query := storage.DB.Where("user_id = ?", ownerID)
+	if ownerRole == utils.RoleSpecialist {
+		substituting := storage.DB.Model(&models.Substitution{}).Select("booking_id").
+			Where("substitute_specialist_id = ? AND status = ?", ownerID, SubstitutionApproved)
+		query = storage.DB.Where("specialist_id = ? OR id IN (?)", ownerID, substituting)
+	}
+	var bookings []models.Booking
+	bookingsExist := query.Where("status IN ?", committedBookingStatuses).Find(&bookings)
+	if bookingsExist.Error != nil {
+		return nil, bookingsExist.Error
+	}
+
+	var userIDs, specialistIDs []uint
+	substitutions := make(map[uint][]models.Substitution)
+	for _, booking := range bookings {
+		userIDs = append(userIDs, booking.UserID)
+		specialistIDs = append(specialistIDs, booking.SpecialistID)
+
+		found, err := bookingSubstitutions(booking.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, substitution := range found {
+			if substitution.SubstituteSpecialistID != nil {
+				specialistIDs = append(specialistIDs, *substitution.SubstituteSpecialistID)
+			}
+		}
+		substitutions[booking.ID] = found
+	}
+	users := make(map[uint]models.User)
+	specialists := make(map[uint]models.Specialist)
+	if len(bookings) > 0 {
+		var userRows []models.User
+		usersExist := storage.DB.Select("id", "first_name", "last_name", "address", "city").Where("id IN ?", userIDs).Find(&userRows)
+		if usersExist.Error != nil {
+			return nil, usersExist.Error
+		}
+		for _, user := range userRows {
+			users[user.ID] = user
+		}
+		var specialistRows []models.Specialist
+		specialistsExist := storage.DB.Select("id", "first_name", "last_name").Where("id IN ?", specialistIDs).Find(&specialistRows)
+		if specialistsExist.Error != nil {
+			return nil, specialistsExist.Error
+		}
+		for _, specialist := range specialistRows {
+			specialists[specialist.ID] = specialist
+		}
+	}
+
+	to := now.AddDate(0, 0, utils.EnvInt("CALENDAR_FEED_DAYS", 60))
+	events := []documents.CalendarEvent{}
+	for _, booking := range bookings {
+		schedule, err := bookingOccurrenceSchedule(&booking)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := acceptedBookingChanges(booking.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		user := users[booking.UserID]
+		address := user.Address
+		if user.City != "" {
+			address = strings.TrimPrefix(address+", "+user.City, ", ")
+		}
+
+		for _, occurrence := range schedule.Between(now, to) {
+			if occurrencePaused(changes, occurrence) {
+				continue
+			}
+			covering := specialistAt(&booking, substitutions[booking.ID], occurrence.Start)
+			if ownerRole == utils.RoleSpecialist && covering != ownerID {
+				continue
+			}
+			counterpartName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+			if ownerRole == utils.RoleUser {
+				specialist := specialists[covering]
+				counterpartName = strings.TrimSpace(specialist.FirstName + " " + specialist.LastName)
+			}
+			events = append(events, documents.CalendarEvent{
+				UID:             fmt.Sprintf("booking-%d-%d@jotno", booking.ID, occurrence.Start.Unix()),
+				Start:           occurrence.Start,
+				End:             occurrence.End,
+				JobType:         booking.JobType,
+				CounterpartName: counterpartName,
+				Address:         address,
+				URL:             appLink(bookingPath(booking.ID)),
+			})
+		}
+	}
+	return events, nil
+}
+
+func calendarFeedOwner(ctx iris.Context) (uint, string) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if claims.IsSpecialist() {
+		return claims.ID, utils.RoleSpecialist
+	}
+	return claims.ID, utils.RoleUser
+}
+
+func revokeCalendarFeeds(tx *gorm.DB, ownerID uint, ownerRole string) error {
+	return tx.Model(&models.CalendarFeed{}).
+		Where("owner_id = ? AND owner_role = ? AND revoked_at IS NULL", ownerID, ownerRole).
+		Update("revoked_at", time.Now()).Error
+}
+
+func hashFeedSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
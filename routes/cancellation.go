@@ -0,0 +1,343 @@
+package routes
+
+import (
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/recurrence"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+const (
+	CancellationFlexible = "flexible"
+	CancellationModerate = "moderate"
+	CancellationStrict   = "strict"
+)
+
+// cancellationTier charges Percent of the next visit's value when a user
+// cancels with at least Notice to go before it.
+type cancellationTier struct {
+	Notice  time.Duration
+	Percent float64
+}
+
+// The tiers of each policy, longest notice first. The last tier of each
+// applies however late the cancellation is.
+var cancellationPolicies = map[string][]cancellationTier{
+	CancellationFlexible: {
+		{Notice: 24 * time.Hour, Percent: 0},
+		{Notice: 0, Percent: 50},
+	},
+	CancellationModerate: {
+		{Notice: 72 * time.Hour, Percent: 0},
+		{Notice: 24 * time.Hour, Percent: 50},
+		{Notice: 0, Percent: 100},
+	},
+	CancellationStrict: {
+		{Notice: 7 * 24 * time.Hour, Percent: 0},
+		{Notice: 48 * time.Hour, Percent: 50},
+		{Notice: 0, Percent: 100},
+	},
+}
+
+// SetCancellationPolicy lets a specialist choose the policy one of their
+// offerings is booked under. Bookings keep the policy they were accepted
+// under.
+func SetCancellationPolicy(ctx iris.Context) {
+	var req CancellationPolicyInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	id := ctx.URLParam("id")
+	jobUpdated := storage.DB.Model(&models.Job{}).
+		Where("id = ? AND specialist_id = ?", req.JobID, id).
+		Update("cancellation_policy", req.Policy)
+	if jobUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if jobUpdated.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// GetCancellationQuote previews what cancelling a booking now would cost the
+// caller, to be shown before they confirm.
+func GetCancellationQuote(ctx iris.Context) {
+	bookingID, parseErr := ctx.URLParamInt("bookingId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid bookingId.", ctx)
+		return
+	}
+
+	booking, role := getBookingForParty(uint(bookingID), ctx)
+	if booking == nil {
+		return
+	}
+
+	quote, err := cancellationQuote(booking, role, time.Now())
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(quote)
+}
+
+// cancellationQuote works out the fee for cancelling a booking. Only users
+// pay one, and only once the specialist has accepted: a share of the value
+// of the next visit that is not paused, set by how much notice it gets under
+// the policy the booking was accepted under. The fee goes to whoever covers
+// that visit, and visits nobody covers since the specialist dropped out
+// carry none. Neither do visits in a period billed already, which the user
+// has been charged for in full.
+func cancellationQuote(booking *models.Booking, role string, now time.Time) (*CancellationQuote, error) {
+	policy, err := bookingCancellationPolicy(booking)
+	if err != nil {
+		return nil, err
+	}
+	quote := CancellationQuote{BookingID: booking.ID, Policy: policy, Fee: money.Zero(booking.Amount.Currency)}
+	if role != utils.RoleUser || booking.Status == BookingRequested {
+		return &quote, nil
+	}
+
+	changes, err := acceptedBookingChanges(booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	substitutions, err := bookingSubstitutions(booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := bookingOccurrenceSchedule(booking)
+	if err != nil {
+		return nil, err
+	}
+	for _, occurrence := range schedule.Between(now, now.AddDate(1, 0, 0)) {
+		if occurrence.Start.After(now) && !occurrencePaused(changes, occurrence) {
+			quote.NextOccurrence = &occurrence
+			break
+		}
+	}
+	if quote.NextOccurrence == nil {
+		return &quote, nil
+	}
+	quote.specialistID = specialistAt(booking, substitutions, quote.NextOccurrence.Start)
+	if quote.specialistID == 0 {
+		return &quote, nil
+	}
+
+	notice := quote.NextOccurrence.Start.Sub(now)
+	quote.NoticeHours = int(notice.Hours())
+	for _, tier := range cancellationPolicies[policy] {
+		if notice >= tier.Notice {
+			quote.Percent = tier.Percent
+			break
+		}
+	}
+	if quote.Percent == 0 {
+		return &quote, nil
+	}
+
+	period, err := occurrencePeriod(*booking, changes, *quote.NextOccurrence)
+	if err != nil || period == nil {
+		return &quote, err
+	}
+	var bills []models.Bill
+	billsExist := storage.DB.Where("booking_id = ?", booking.ID).Find(&bills)
+	if billsExist.Error != nil {
+		return nil, billsExist.Error
+	}
+	if len(billsForPeriod(bills, *period)) > 0 {
+		quote.AlreadyBilled = true
+		return &quote, nil
+	}
+
+	quote.Fee = occurrenceValue(*period, *quote.NextOccurrence).Percent(quote.Percent)
+	return &quote, nil
+}
+
+// bookingCancellationPolicy is the policy the booking was accepted under.
+// Bookings not accepted yet would be accepted under the offering's current
+// one.
+func bookingCancellationPolicy(booking *models.Booking) (string, error) {
+	if _, ok := cancellationPolicies[booking.CancellationPolicy]; ok {
+		return booking.CancellationPolicy, nil
+	}
+	return offeringCancellationPolicy(storage.DB, booking.SpecialistID, booking.JobType)
+}
+
+// offeringCancellationPolicy is the policy of the specialist's offering of a
+// job, flexible when there is none.
+func offeringCancellationPolicy(tx *gorm.DB, specialistID uint, jobType string) (string, error) {
+	var job models.Job
+	jobExists := tx.Where("specialist_id = ? AND job_name = ?", specialistID, jobType).Find(&job)
+	if jobExists.Error != nil {
+		return "", jobExists.Error
+	}
+	if _, ok := cancellationPolicies[job.CancellationPolicy]; !ok {
+		return CancellationFlexible, nil
+	}
+	return job.CancellationPolicy, nil
+}
+
+// occurrencePeriod is the billing period a visit falls in, or nil when it
+// falls in none.
+func occurrencePeriod(booking models.Booking, changes []models.BookingChangeRequest, occurrence recurrence.Occurrence) (*billingPeriod, error) {
+	periods, err := bookingPeriods(booking, changes, nil, occurrence.Start)
+	if err != nil || len(periods) == 0 {
+		return nil, err
+	}
+	return &periods[len(periods)-1], nil
+}
+
+// occurrenceValue is the share of its billing period's amount one visit is
+// worth, by its part of the period's scheduled time.
+func occurrenceValue(period billingPeriod, occurrence recurrence.Occurrence) money.Money {
+	if period.Scheduled <= 0 {
+		return money.Zero(period.Amount.Currency)
+	}
+	share := overlapWithin(occurrence, period.Start, period.Start.Add(period.Full))
+	return period.Amount.Scale(share.Hours() / period.Scheduled.Hours())
+}
+
+// createCancellationFee bills the user for a late cancellation, covering the
+// visit they cancelled.
+func createCancellationFee(tx *gorm.DB, booking *models.Booking, quote *CancellationQuote) error {
+	bill := models.Bill{
+		BookingID:      booking.ID,
+		SpecialistID:   quote.specialistID,
+		Kind:           BillKindCancellationFee,
+		Amount:         quote.Fee,
+		PlatformFee:    commissionFor(booking.JobType, quote.Fee),
+		RefundedAmount: money.Zero(quote.Fee.Currency),
+		PeriodStart:    quote.NextOccurrence.Start,
+		PeriodEnd:      quote.NextOccurrence.End,
+	}
+	billCreated := tx.Create(&bill)
+	if billCreated.Error != nil {
+		return billCreated.Error
+	}
+	return postBillCreated(tx, booking, &bill)
+}
+
+// flagRepeatCanceller flags a specialist who has cancelled or dropped out of
+// at least SPECIALIST_CANCELLATION_FLAG_COUNT bookings within the last
+// SPECIALIST_CANCELLATION_WINDOW_DAYS, so admins can follow up.
+func flagRepeatCanceller(tx *gorm.DB, specialistID uint, now time.Time) error {
+	since := now.AddDate(0, 0, -utils.EnvInt("SPECIALIST_CANCELLATION_WINDOW_DAYS", 90))
+	var cancellations int64
+	cancellationsCounted := tx.Model(&models.BookingHistory{}).
+		Where("actor_id = ? AND actor_role = ? AND to_status = ? AND created_at >= ?", specialistID, utils.RoleSpecialist, BookingCancelled, since).
+		Count(&cancellations)
+	if cancellationsCounted.Error != nil {
+		return cancellationsCounted.Error
+	}
+	var dropOuts int64
+	dropOutsCounted := tx.Model(&models.Substitution{}).
+		Where("original_specialist_id = ? AND created_at >= ?", specialistID, since).
+		Count(&dropOuts)
+	if dropOutsCounted.Error != nil {
+		return dropOutsCounted.Error
+	}
+	if cancellations+dropOuts < int64(utils.EnvInt("SPECIALIST_CANCELLATION_FLAG_COUNT", 3)) {
+		return nil
+	}
+	return tx.Model(&models.Specialist{}).
+		Where("id = ? AND cancellation_flagged_at IS NULL", specialistID).
+		Update("cancellation_flagged_at", now).Error
+}
+
+// GetFlaggedSpecialists lists the specialists flagged for cancelling often,
+// most recently flagged first.
+func GetFlaggedSpecialists(ctx iris.Context) {
+	var specialists []models.Specialist
+	specialistsExist := storage.DB.
+		Select("id", "first_name", "last_name", "email", "phone_number", "cancellation_flagged_at").
+		Where("cancellation_flagged_at IS NOT NULL").
+		Order("cancellation_flagged_at DESC").
+		Find(&specialists)
+	if specialistsExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	flagged := []FlaggedSpecialist{}
+	for _, specialist := range specialists {
+		flagged = append(flagged, FlaggedSpecialist{
+			ID:                    specialist.ID,
+			FirstName:             specialist.FirstName,
+			LastName:              specialist.LastName,
+			Email:                 specialist.Email,
+			PhoneNumber:           specialist.PhoneNumber,
+			CancellationFlaggedAt: *specialist.CancellationFlaggedAt,
+		})
+	}
+	ctx.JSON(flagged)
+}
+
+// ClearSpecialistFlag lifts the flag once an admin has followed up. The next
+// cancellation flags them again while they are still over the limit.
+func ClearSpecialistFlag(ctx iris.Context) {
+	var req ClearSpecialistFlagInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	flagCleared := storage.DB.Model(&models.Specialist{}).
+		Where("id = ? AND cancellation_flagged_at IS NOT NULL", req.SpecialistID).
+		Update("cancellation_flagged_at", nil)
+	if flagCleared.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if flagCleared.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func describeCancellationFee(quote *CancellationQuote) string {
+	return fmt.Sprintf("A cancellation fee of %s (%g%% of the next visit) was charged under the %s policy.", quote.Fee.String(), quote.Percent, quote.Policy)
+}
+
+type CancellationPolicyInput struct {
+	JobID  uint   `json:"jobID" validate:"required"`
+	Policy string `json:"policy" validate:"required,oneof=flexible moderate strict"`
+}
+
+type CancellationQuote struct {
+	BookingID      uint                   `json:"bookingID"`
+	Policy         string                 `json:"policy"`
+	NextOccurrence *recurrence.Occurrence `json:"nextOccurrence"`
+	NoticeHours    int                    `json:"noticeHours"`
+	Percent        float64                `json:"percent"`
+	Fee            money.Money            `json:"fee"`
+	AlreadyBilled  bool                   `json:"alreadyBilled"`
+	specialistID   uint
+}
+
+type ClearSpecialistFlagInput struct {
+	SpecialistID uint `json:"specialistID" validate:"required"`
+}
+
+type FlaggedSpecialist struct {
+	ID                    uint      `json:"ID"`
+	FirstName             string    `json:"firstName"`
+	LastName              string    `json:"lastName"`
+	Email                 string    `json:"email"`
+	PhoneNumber           string    `json:"phoneNumber"`
+	CancellationFlaggedAt time.Time `json:"cancellationFlaggedAt"`
+}
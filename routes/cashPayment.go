@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// MarkBillPaid records the user's word that a bill was paid in cash. The bill
+// is complete once the specialist has confirmed receipt as well.
+func MarkBillPaid(ctx iris.Context) {
+	var req BillActionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	bill, booking, role := getBillForParty(req.BillID, ctx)
+	if bill == nil {
+		return
+	}
+	if role != utils.RoleUser {
+		utils.CreateForbidden(ctx)
+		return
+	}
+
+	err = markCashBill(bill, booking, "paid", map[string]interface{}{
+		"paid": true, "paid_at": time.Now(), "complete": gorm.Expr("received"),
+	})
+	if errors.Is(err, errBillAlreadyMarked) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This bill is already marked as paid.", ctx)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	body := "Please confirm you received the cash payment."
+	if bill.Complete {
+		body = "The bill is now settled."
+	}
+	notifyBookingParty(booking, role, "Bill marked as paid", body)
+	ctx.JSON(bill)
+}
+
+// ConfirmBillReceived records the specialist's word that the money for a bill
+// arrived. The bill is complete once the user has marked it paid as well.
+func ConfirmBillReceived(ctx iris.Context) {
+	var req BillActionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	bill, booking, role := getBillForParty(req.BillID, ctx)
+	if bill == nil {
+		return
+	}
+	if role != utils.RoleSpecialist {
+		utils.CreateForbidden(ctx)
+		return
+	}
+
+	err = markCashBill(bill, booking, "received", map[string]interface{}{
+		"received": true, "received_at": time.Now(), "complete": gorm.Expr("paid"),
+	})
+	if errors.Is(err, errBillAlreadyMarked) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This bill is already marked as received.", ctx)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	body := "Your specialist received your payment. Please mark the bill as paid."
+	if bill.Complete {
+		body = "Your specialist received your payment. The bill is now settled."
+	}
+	notifyBookingParty(booking, role, "Payment received", body)
+	ctx.JSON(bill)
+}
+
+var errBillAlreadyMarked = errors.New("bill is already marked")
+
+// markCashBill records one side's confirmation, where flag is the column that
+// side sets. Once both sides agree the bill is complete and the cash payment
+// is posted to the ledger.
+func markCashBill(bill *models.Bill, booking *models.Booking, flag string, updates map[string]interface{}) error {
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		// A bill settled online in the meantime takes no cash confirmation.
+		billMarked := tx.Model(&models.Bill{}).Where("id = ? AND "+flag+" = false AND complete = false", bill.ID).Updates(updates)
+		if billMarked.Error != nil {
+			return billMarked.Error
+		}
+		if billMarked.RowsAffected == 0 {
+			return errBillAlreadyMarked
+		}
+
+		billExists := tx.Where("id = ?", bill.ID).Find(bill)
+		if billExists.Error != nil || !bill.Complete {
+			return billExists.Error
+		}
+		return postBillPaid(tx, booking, bill, false)
+	})
+}
+
+// OpenCashDisputes opens a dispute for every bill the user marked as paid more
+// than CASH_DISPUTE_DAYS ago that the specialist still has not confirmed. It
+// runs from the scheduler.
+func OpenCashDisputes(report *JobReport) error {
+	cutoff := time.Now().AddDate(0, 0, -utils.EnvInt("CASH_DISPUTE_DAYS", 7))
+
+	var bills []models.Bill
+	billsExist := storage.DB.
+		Where("paid = true AND received = false AND complete = false AND paid_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM bill_disputes WHERE bill_disputes.bill_id = bills.id)").
+		Find(&bills)
+	if billsExist.Error != nil {
+		return billsExist.Error
+	}
+
+	for _, bill := range bills {
+		dispute := models.BillDispute{
+			BillID:       bill.ID,
+			BookingID:    bill.BookingID,
+			Status:       DisputeOpen,
+			OpenedByRole: ActorSystem,
+			ReasonCode:   DisputeNotReceived,
+			Reason:       "The payment was not confirmed by the specialist.",
+		}
+		disputeErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+			return openDispute(tx, &dispute)
+		})
+		if disputeErr != nil {
+			report.Fail(fmt.Errorf("bill %d: %w", bill.ID, disputeErr))
+			continue
+		}
+		report.Processed()
+
+		var booking models.Booking
+		bookingExists := storage.DB.Where("id = ?", bill.BookingID).Find(&booking)
+		if bookingExists.Error == nil && bookingExists.RowsAffected == 1 {
+			notifyBookingParty(&booking, ActorSystem, "Payment disputed", "A cash payment was not confirmed, so we opened a dispute.")
+		}
+	}
+	return nil
+}
+
+type BillActionInput struct {
+	BillID uint `json:"billID" validate:"required"`
+}
@@ -6,8 +6,10 @@ import (
 	"jotno-server/storage"
 	"jotno-server/utils"
 	"sort"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
 	"gorm.io/gorm"
 )
 
@@ -20,6 +22,16 @@ func CreateChat(ctx iris.Context) {
 		return
 	}
 
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !chatParticipant(req.UserID, req.SpecialistID, claims) {
+		utils.CreateForbidden(ctx)
+		return
+	}
+	receiverID := req.SpecialistID
+	if claims.IsSpecialist() {
+		receiverID = req.UserID
+	}
+
 	var prevChat models.Chat
 	chatExists := storage.DB.
 		Where("user_id = ? AND specialist_id = ? AND job_id = ?", req.UserID, req.SpecialistID, req.JobID).
@@ -38,8 +50,9 @@ func CreateChat(ctx iris.Context) {
 
 	var messages []models.Message
 	messages = append(messages, models.Message{
-		SenderID:   req.SenderID,
-		ReceiverID: req.ReceiverID,
+		SenderID:   claims.ID,
+		SenderRole: recipientRole(claims),
+		ReceiverID: receiverID,
 		Text:       req.Text,
 	})
 
@@ -55,6 +68,8 @@ func CreateChat(ctx iris.Context) {
 	ctx.JSON(chat)
 }
 
+// GetChatByUserAndSpecialistID returns the chat header only; its messages are
+// paged through GetChatMessages.
 func GetChatByUserAndSpecialistID(ctx iris.Context) {
 	var req GetChatInput
 	err := ctx.ReadJSON(&req)
@@ -64,23 +79,21 @@ func GetChatByUserAndSpecialistID(ctx iris.Context) {
 		return
 	}
 
-	result, err := getChatResultsByUserIDAndSpecialistID(req.UserID, req.SpecialistID, req.JobID, ctx)
-	if err != nil {
+	if !chatParticipant(req.UserID, req.SpecialistID, jwt.Get(ctx).(*utils.AccessToken)) {
+		utils.CreateForbidden(ctx)
 		return
 	}
-	var messages []models.Message
-	messagesQuery := storage.DB.Where("chat_id = ?", result.ID).Order("created_at DESC").Find(&messages)
 
-	if messagesQuery.Error != nil {
-		utils.InternalServerError(ctx)
+	result, err := getChatResultsByUserIDAndSpecialistID(req.UserID, req.SpecialistID, req.JobID, ctx)
+	if err != nil {
 		return
 	}
 
-	result.Messages = messages
-
 	ctx.JSON(result)
 }
 
+// GetChatByID returns the chat header only; its messages are paged through
+// GetChatMessages.
 func GetChatByID(ctx iris.Context) {
 	id := ctx.URLParam("chatId")
 
@@ -90,23 +103,18 @@ func GetChatByID(ctx iris.Context) {
 		return
 	}
 
-	var messages []models.Message
-	messagesQuery := storage.DB.Where("chat_id = ?", id).Order("created_at DESC").Find(&messages)
-
-	if messagesQuery.Error != nil {
-		utils.InternalServerError(ctx)
+	if !chatParticipant(result.UserID, result.SpecialistID, jwt.Get(ctx).(*utils.AccessToken)) {
+		utils.CreateForbidden(ctx)
 		return
 	}
 
-	result.Messages = messages
-
 	ctx.JSON(result)
 }
 
 func GetChatsByUserID(ctx iris.Context) {
 	id := ctx.URLParam("id")
 
-	results, err := getChatResultsByUserID(id, ctx)
+	results, err := getChatResultsByUserID(id, jwt.Get(ctx).(*utils.AccessToken), ctx)
 
 	if err != nil {
 		return
@@ -119,17 +127,20 @@ func GetChatsByUserID(ctx iris.Context) {
 
 	var messages []models.Message
 
+	// Deleted messages are left out, so the preview is the latest message
+	// still in the chat.
 	messagesQuery := storage.DB.Raw(`
 		SELECT messages.* 
 		FROM messages
 		INNER JOIN (
 			SELECT chat_id, MAX(created_at) AS created_at
 			FROM messages
-			WHERE chat_id IN ? 
+			WHERE chat_id IN ? AND deleted_at IS NULL
 			GROUP BY chat_id
 		) AS recentMessages
 		ON messages.chat_id = recentMessages.chat_id 
-		AND messages.created_at = recentMessages.created_at`, chatIDs).Scan(&messages)
+		AND messages.created_at = recentMessages.created_at
+		WHERE messages.deleted_at IS NULL`, chatIDs).Scan(&messages)
 
 	messageMap := make(map[uint][]models.Message)
 	for _, message := range messages {
@@ -147,12 +158,21 @@ func GetChatsByUserID(ctx iris.Context) {
 	}
 
 	sort.Slice(results, func(i int, j int) bool {
-		return results[i].Messages[0].CreatedAt.After(results[j].Messages[0].CreatedAt)
+		return lastChatActivity(results[i]).After(lastChatActivity(results[j]))
 	})
 
 	ctx.JSON(results)
 }
 
+// lastChatActivity is when the chat's latest message was sent, or when the
+// chat was opened once every message in it was deleted.
+func lastChatActivity(result ChatResult) time.Time {
+	if len(result.Messages) == 0 {
+		return result.CreatedAt
+	}
+	return result.Messages[0].CreatedAt
+}
+
 func getChatResult(id string, ctx iris.Context) (ChatResult, error) {
 	var result ChatResult
 	resultQuery := storage.DB.Table("chats").
@@ -205,7 +225,14 @@ func getChatResultsByUserIDAndSpecialistID(userId uint, specialistId uint, jobId
 	return result, nil
 }
 
-func getChatResultsByUserID(id string, ctx iris.Context) ([]ChatResult, error) {
+// getChatResultsByUserID lists the chats of the caller, looking the ID up
+// on the side of the chat their role puts them.
+func getChatResultsByUserID(id string, claims *utils.AccessToken, ctx iris.Context) ([]ChatResult, error) {
+	participantColumn := "chats.user_id"
+	if claims.IsSpecialist() {
+		participantColumn = "chats.specialist_id"
+	}
+
 	var result []ChatResult
 	resultQuery := storage.DB.Table("chats").
 		Select(`chats.*,
@@ -215,7 +242,7 @@ func getChatResultsByUserID(id string, ctx iris.Context) ([]ChatResult, error) {
 		Joins("INNER JOIN jobs on chats.job_id = jobs.id").
 		Joins("INNER JOIN specialists on chats.specialist_id = specialists.id").
 		Joins("INNER JOIN users on chats.user_id = users.id").
-		Where("chats.user_id = ?", id).Or("chats.specialist_id = ?", id).
+		Where(participantColumn+" = ?", id).
 		Scan(&result)
 
 	if resultQuery.Error != nil {
@@ -247,15 +274,13 @@ type ChatResult struct {
 	UserLastName  string `json:"userLastName"`
 	UserAvatar    string `json:"userAvatar"`
 	// Message
-	Messages []models.Message `gorm:"foreignKey:ID" json:"messages"`
+	Messages []models.Message `gorm:"foreignKey:ID" json:"messages,omitempty"`
 }
 
 type CreateChatInput struct {
 	UserID       uint   `json:"userID" validate:"required"`
 	SpecialistID uint   `json:"specialistID" validate:"required"`
 	JobID        uint   `json:"jobID" validate:"required"`
-	SenderID     uint   `json:"senderID" validate:"required"`
-	ReceiverID   uint   `json:"receiverID" validate:"required"`
 	Text         string `json:"text" validate:"required,lt=5000"`
 }
 
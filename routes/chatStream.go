@@ -0,0 +1,193 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	ChatMessageCreated = "messageCreated"
+	ChatMessageEdited  = "messageEdited"
+	ChatMessageDeleted = "messageDeleted"
+)
+
+// ChatWebSocket streams a chat's new, edited and deleted messages to one of
+// its participants over a WebSocket. Browsers cannot set headers on the
+// handshake, so the access token may come as the token query parameter.
+func ChatWebSocket(ctx iris.Context) {
+	chatID, parseErr := ctx.URLParamInt("chatId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid chatId.", ctx)
+		return
+	}
+
+	chat := getChatForParticipant(uint(chatID), ctx)
+	if chat == nil {
+		return
+	}
+
+	server := websocket.Server{
+		// Clients authenticate with the access token rather than cookies, and
+		// the mobile apps send no Origin, so any origin is accepted.
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			streamCtx, cancel := context.WithCancel(conn.Request().Context())
+			defer cancel()
+
+			// Clients only listen; reading tells us when they go away.
+			go func() {
+				defer cancel()
+				var discarded string
+				for websocket.Message.Receive(conn, &discarded) == nil {
+				}
+			}()
+
+			err := streamChatEvents(streamCtx, chat.ID, func(payload string) error {
+				return websocket.Message.Send(conn, payload)
+			}, nil)
+			if err != nil {
+				log.Println("chat websocket closed:", chat.ID, err)
+			}
+		},
+	}
+	server.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
+}
+
+// ChatEvents is the Server-Sent Events fallback of ChatWebSocket, for
+// clients that cannot open a WebSocket. It sends a comment every
+// CHAT_KEEPALIVE_SECONDS so proxies keep the connection open.
+func ChatEvents(ctx iris.Context) {
+	chatID, parseErr := ctx.URLParamInt("chatId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid chatId.", ctx)
+		return
+	}
+
+	chat := getChatForParticipant(uint(chatID), ctx)
+	if chat == nil {
+		return
+	}
+
+	ctx.ContentType("text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.StatusCode(iris.StatusOK)
+	ctx.ResponseWriter().Flush()
+
+	keepAlive := time.NewTicker(time.Duration(utils.EnvInt("CHAT_KEEPALIVE_SECONDS", 25)) * time.Second)
+	defer keepAlive.Stop()
+
+	err := streamChatEvents(ctx.Request().Context(), chat.ID, func(payload string) error {
+		line := ": keep-alive\n\n"
+		if payload != "" {
+			line = "data: " + payload + "\n\n"
+		}
+		_, err := ctx.WriteString(line)
+		ctx.ResponseWriter().Flush()
+		return err
+	}, keepAlive.C)
+	if err != nil {
+		log.Println("chat event stream closed:", chat.ID, err)
+	}
+}
+
+// streamChatEvents relays the events published for a chat on any instance
+// to send until ctx is done or sending fails. Each tick of keepAlive sends
+// an empty payload.
+func streamChatEvents(ctx context.Context, chatID uint, send func(payload string) error, keepAlive <-chan time.Time) error {
+	subscription := storage.Redis.Subscribe(ctx, chatChannel(chatID))
+	defer subscription.Close()
+	_, err := subscription.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	events := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive:
+			err := send("")
+			if err != nil {
+				return err
+			}
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err := send(event.Payload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publishChatEvent tells everyone streaming the chat, on every instance,
+// about a change to one of its messages. Clients that miss it catch up the
+// next time they load the chat.
+func publishChatEvent(eventType string, message models.Message) {
+	payload, err := json.Marshal(ChatEvent{Type: eventType, Message: message})
+	if err != nil {
+		log.Println("could not encode chat event:", message.ChatID, err)
+		return
+	}
+	err = storage.Redis.Publish(context.Background(), chatChannel(message.ChatID), payload).Err()
+	if err != nil {
+		log.Println("could not publish chat event:", message.ChatID, err)
+	}
+}
+
+// getChatForParticipant loads a chat when the caller is its user or
+// specialist.
+func getChatForParticipant(chatID uint, ctx iris.Context) *models.Chat {
+	var chat models.Chat
+	chatExists := storage.DB.Where("id = ?", chatID).Find(&chat)
+	if chatExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	if chatExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	if !chatParticipant(chat.UserID, chat.SpecialistID, jwt.Get(ctx).(*utils.AccessToken)) {
+		utils.CreateForbidden(ctx)
+		return nil
+	}
+	return &chat
+}
+
+// chatParticipant reports whether the caller is the chat's user or its
+// specialist. The two are told apart by role, as their IDs may coincide.
+func chatParticipant(userID uint, specialistID uint, claims *utils.AccessToken) bool {
+	if claims.IsSpecialist() {
+		return specialistID == claims.ID
+	}
+	return userID == claims.ID
+}
+
+func chatChannel(chatID uint) string {
+	return "chat:" + strconv.FormatUint(uint64(chatID), 10)
+}
+
+type ChatEvent struct {
+	Type    string         `json:"type"`
+	Message models.Message `json:"message"`
+}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm/clause"
+)
+
+// commissionFor is the platform's cut of an amount billed for a job type. Job
+// types without a rule fall back to PLATFORM_FEE_PERCENT.
+func commissionFor(jobType string, amount money.Money) money.Money {
+	percent := utils.EnvFloat("PLATFORM_FEE_PERCENT", 0)
+
+	var rule models.CommissionRule
+	ruleExists := storage.DB.Where("job_type = ?", jobType).Limit(1).Find(&rule)
+	if ruleExists.Error == nil && ruleExists.RowsAffected == 1 {
+		percent = rule.Percent
+	}
+	return amount.Percent(percent)
+}
+
+func GetCommissionRules(ctx iris.Context) {
+	var rules []models.CommissionRule
+	rulesExist := storage.DB.Order("job_type").Find(&rules)
+	if rulesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(rules)
+}
+
+// SetCommissionRule creates or replaces the rule for a job type. It only
+// affects bills created from then on.
+func SetCommissionRule(ctx iris.Context) {
+	var req CommissionRuleInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	rule := models.CommissionRule{JobType: req.JobType, Percent: req.Percent}
+	ruleSaved := storage.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"percent", "updated_at"}),
+	}).Create(&rule)
+	if ruleSaved.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(rule)
+}
+
+type CommissionRuleInput struct {
+	JobType string  `json:"jobType" validate:"required,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
+	Percent float64 `json:"percent" validate:"gte=0,lte=100"`
+}
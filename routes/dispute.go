@@ -0,0 +1,519 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/payments"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	DisputeOpen     = "open"
+	DisputeResolved = "resolved"
+)
+
+const (
+	DisputeNotReceived        = "notReceived"
+	DisputeWrongAmount        = "wrongAmount"
+	DisputeServiceNotProvided = "serviceNotProvided"
+	DisputePoorService        = "poorService"
+	DisputeDuplicateCharge    = "duplicateCharge"
+	DisputeOther              = "other"
+)
+
+const (
+	OutcomeRefundFull    = "refundFull"
+	OutcomeRefundPartial = "refundPartial"
+	OutcomeRejected      = "rejected"
+)
+
+var errDisputeResolved = errors.New("dispute is already resolved")
+
+// refundProviderCash marks refunds of cash payments, which the specialist
+// hands back in person.
+const refundProviderCash = "cash"
+
+func OpenDispute(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req OpenDisputeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	bill, booking, role := getBillForParty(req.BillID, ctx)
+	if bill == nil {
+		return
+	}
+	if bill.DisputeStatus != "" {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This bill has already been disputed.", ctx)
+		return
+	}
+
+	dispute := models.BillDispute{
+		BillID:       bill.ID,
+		BookingID:    booking.ID,
+		Status:       DisputeOpen,
+		OpenedByID:   claims.ID,
+		OpenedByRole: role,
+		ReasonCode:   req.ReasonCode,
+		Reason:       req.Reason,
+	}
+	disputeErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		return openDispute(tx, &dispute)
+	})
+	if disputeErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifyBookingParty(booking, role, "Bill disputed", "A dispute was opened on a bill for this booking.")
+	ctx.JSON(dispute)
+}
+
+func GetDispute(ctx iris.Context) {
+	var query DisputeQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	dispute, _, _ := getDisputeForParty(query.DisputeID, ctx)
+	if dispute == nil {
+		return
+	}
+
+	timesheet, err := disputeTimesheet(dispute)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(DisputeView{BillDispute: *dispute, Timesheet: timesheet})
+}
+
+func AddDisputeEvidence(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req DisputeEvidenceInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	dispute, booking, role := getDisputeForParty(req.DisputeID, ctx)
+	if dispute == nil {
+		return
+	}
+	if dispute.Status != DisputeOpen {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This dispute is already resolved.", ctx)
+		return
+	}
+
+	name := fmt.Sprintf("disputes/%d/%d.png", dispute.ID, time.Now().UnixNano())
+	upload := storage.UploadBase64Image(req.Image, name)
+
+	evidence := models.DisputeEvidence{
+		DisputeID:    dispute.ID,
+		UploaderID:   claims.ID,
+		UploaderRole: role,
+		URL:          upload["url"],
+		Description:  req.Description,
+	}
+	evidenceCreated := storage.DB.Create(&evidence)
+	if evidenceCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifyBookingParty(booking, role, "New dispute evidence", "The other party added evidence to a dispute.")
+	ctx.JSON(evidence)
+}
+
+func AddDisputeMessage(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req DisputeMessageInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	dispute, booking, role := getDisputeForParty(req.DisputeID, ctx)
+	if dispute == nil {
+		return
+	}
+
+	message := createDisputeMessage(dispute, claims.ID, role, req.Text, ctx)
+	if message == nil {
+		return
+	}
+	notifyBookingParty(booking, role, "New dispute message", req.Text)
+	ctx.JSON(message)
+}
+
+// GetDisputes lists disputes for admins, open ones by default.
+func GetDisputes(ctx iris.Context) {
+	status := ctx.URLParamDefault("status", DisputeOpen)
+
+	var disputes []models.BillDispute
+	disputesExist := storage.DB.
+		Preload("Evidence").
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).
+		Where("status = ?", status).
+		Order("created_at").
+		Limit(100).
+		Find(&disputes)
+	if disputesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	views := []DisputeView{}
+	for i := range disputes {
+		timesheet, err := disputeTimesheet(&disputes[i])
+		if err != nil {
+			utils.InternalServerError(ctx)
+			return
+		}
+		views = append(views, DisputeView{BillDispute: disputes[i], Timesheet: timesheet})
+	}
+	ctx.JSON(views)
+}
+
+func AddAdminDisputeMessage(ctx iris.Context) {
+	var req DisputeMessageInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	dispute, booking := getDispute(req.DisputeID, ctx)
+	if dispute == nil {
+		return
+	}
+
+	message := createDisputeMessage(dispute, 0, utils.RoleAdmin, req.Text, ctx)
+	if message == nil {
+		return
+	}
+	notifyBookingParty(booking, utils.RoleAdmin, "Message from Jotno support", req.Text)
+	ctx.JSON(message)
+}
+
+// ResolveDispute closes a dispute for an admin. A refund of a paid bill goes
+// back through the provider that took the payment, or is owed in cash by the
+// specialist; on an unpaid bill it writes down what is still owed.
+func ResolveDispute(ctx iris.Context) {
+	var req ResolveDisputeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	dispute, booking := getDispute(req.DisputeID, ctx)
+	if dispute == nil {
+		return
+	}
+	if dispute.Status != DisputeOpen {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This dispute is already resolved.", ctx)
+		return
+	}
+
+	var bill models.Bill
+	billExists := storage.DB.Where("id = ?", dispute.BillID).Find(&bill)
+	if billExists.Error != nil || billExists.RowsAffected == 0 {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	remaining, err := bill.Amount.Sub(bill.RefundedAmount)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	refundAmount := money.Zero(bill.Amount.Currency)
+	switch req.Outcome {
+	case OutcomeRefundFull:
+		refundAmount = remaining
+	case OutcomeRefundPartial:
+		if req.Amount == nil || req.Amount.Currency != remaining.Currency || !req.Amount.IsPositive() || req.Amount.Minor > remaining.Minor {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "The refund must be more than 0 and at most "+remaining.String()+".", ctx)
+			return
+		}
+		refundAmount = *req.Amount
+	}
+
+	var refund *models.Refund
+	if refundAmount.IsPositive() && bill.Paid {
+		refund, err = issueRefund(&bill, dispute.ID, refundAmount, req.Note)
+		if err != nil {
+			utils.CreateError(iris.StatusBadGateway, "Refund Error", "The refund could not be issued. Please try again.", ctx)
+			return
+		}
+	}
+
+	now := time.Now()
+	dispute.Status = DisputeResolved
+	dispute.Outcome = req.Outcome
+	dispute.RefundAmount = refundAmount
+	dispute.ResolutionNote = req.Note
+	dispute.ResolvedAt = &now
+
+	transactionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the resolution that closes the dispute goes on to the bill, so
+		// two admins resolving it at once do not count the refund twice.
+		disputeResolved := tx.Model(dispute).Where("status = ?", DisputeOpen).Updates(map[string]interface{}{
+			"status":                 dispute.Status,
+			"outcome":                dispute.Outcome,
+			"refund_amount_minor":    dispute.RefundAmount.Minor,
+			"refund_amount_currency": dispute.RefundAmount.Currency,
+			"resolution_note":        dispute.ResolutionNote,
+			"resolved_at":            dispute.ResolvedAt,
+		})
+		if disputeResolved.Error != nil {
+			return disputeResolved.Error
+		}
+		if disputeResolved.RowsAffected == 0 {
+			return errDisputeResolved
+		}
+
+		billUpdates := map[string]interface{}{
+			"refunded_amount_minor":    gorm.Expr("refunded_amount_minor + ?", refundAmount.Minor),
+			"refunded_amount_currency": refundAmount.Currency,
+			"dispute_status":           DisputeResolved,
+		}
+		if !bill.Paid && refundAmount.Minor >= remaining.Minor {
+			billUpdates["complete"] = true
+		}
+		billUpdated := tx.Model(&bill).Updates(billUpdates)
+		if billUpdated.Error != nil {
+			return billUpdated.Error
+		}
+
+		if refundAmount.IsPositive() {
+			method := refundWriteDown
+			if refund != nil && refund.Provider == refundProviderCash {
+				method = refundCash
+			} else if refund != nil {
+				method = refundOnline
+			}
+			ledgerErr := postRefund(tx, booking, &bill, dispute.ID, refundAmount, method)
+			if ledgerErr != nil {
+				return ledgerErr
+			}
+		}
+
+		history := models.BookingHistory{
+			BookingID:  booking.ID,
+			FromStatus: booking.Status,
+			ToStatus:   booking.Status,
+			ActorRole:  utils.RoleAdmin,
+			Reason:     "Dispute resolved (" + req.Outcome + "), refunded " + refundAmount.String() + ".",
+			BillID:     &bill.ID,
+		}
+		return tx.Create(&history).Error
+	})
+	if errors.Is(transactionErr, errDisputeResolved) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This dispute is already resolved.", ctx)
+		return
+	}
+	if transactionErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	body := "The dispute was closed without a refund."
+	if refundAmount.IsPositive() {
+		body = refundAmount.String() + " will be refunded."
+		if refund != nil && refund.Provider == refundProviderCash {
+			body = "The specialist will return " + refundAmount.String() + " in cash."
+		}
+	}
+	notifyBookingParty(booking, utils.RoleAdmin, "Dispute resolved", body)
+	ctx.JSON(dispute)
+}
+
+// openDispute stores a new dispute and flags its bill as disputed.
+func openDispute(tx *gorm.DB, dispute *models.BillDispute) error {
+	disputeCreated := tx.Create(dispute)
+	if disputeCreated.Error != nil {
+		return disputeCreated.Error
+	}
+	return tx.Model(&models.Bill{}).Where("id = ?", dispute.BillID).Update("dispute_status", DisputeOpen).Error
+}
+
+// issueRefund refunds a paid bill. The refund row is written before the
+// provider is called and is keyed by dispute, so retrying a resolution never
+// refunds twice.
+func issueRefund(bill *models.Bill, disputeID uint, amount money.Money, reason string) (*models.Refund, error) {
+	var attempt models.PaymentAttempt
+	attemptExists := storage.DB.Where("bill_id = ? AND status = ?", bill.ID, payments.StatusSucceeded).Limit(1).Find(&attempt)
+	if attemptExists.Error != nil {
+		return nil, attemptExists.Error
+	}
+
+	refund := models.Refund{
+		BillID:    bill.ID,
+		DisputeID: disputeID,
+		Provider:  refundProviderCash,
+		Amount:    amount,
+		Status:    payments.StatusPending,
+	}
+	if attemptExists.RowsAffected == 1 {
+		refund.Provider = attempt.Provider
+		refund.PaymentAttemptID = &attempt.ID
+	}
+	refundCreated := storage.DB.Where(models.Refund{DisputeID: disputeID}).FirstOrCreate(&refund)
+	if refundCreated.Error != nil {
+		return nil, refundCreated.Error
+	}
+	if refund.Provider == refundProviderCash || refund.Status == payments.StatusSucceeded || refund.ProviderReference != "" {
+		return &refund, nil
+	}
+
+	provider, err := payments.Get(refund.Provider)
+	if err != nil {
+		return nil, err
+	}
+	result, err := provider.Refund(payments.RefundRequest{
+		Reference:         attempt.Reference,
+		ProviderReference: attempt.ProviderReference,
+		Amount:            amount,
+		Reason:            reason,
+	})
+	if err != nil {
+		storage.DB.Model(&refund).Updates(map[string]interface{}{"status": payments.StatusFailed, "raw_response": err.Error()})
+		return nil, err
+	}
+
+	refund.Amount = amount
+	refund.Status = result.Status
+	refund.ProviderReference = result.ProviderReference
+	refund.RawResponse = result.RawResponse
+	refundUpdated := storage.DB.Select("amount_minor", "amount_currency", "status", "provider_reference", "raw_response", "updated_at").Save(&refund)
+	return &refund, refundUpdated.Error
+}
+
+func createDisputeMessage(dispute *models.BillDispute, senderID uint, senderRole string, text string, ctx iris.Context) *models.DisputeMessage {
+	if dispute.Status != DisputeOpen {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This dispute is already resolved.", ctx)
+		return nil
+	}
+
+	message := models.DisputeMessage{
+		DisputeID:  dispute.ID,
+		SenderID:   senderID,
+		SenderRole: senderRole,
+		Text:       text,
+	}
+	messageCreated := storage.DB.Create(&message)
+	if messageCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	return &message
+}
+
+// loadDispute loads a dispute with its evidence and messages.
+func loadDispute(disputeID uint, ctx iris.Context) *models.BillDispute {
+	var dispute models.BillDispute
+	disputeExists := storage.DB.
+		Preload("Evidence").
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).
+		Where("id = ?", disputeID).
+		Find(&dispute)
+	if disputeExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	if disputeExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+	return &dispute
+}
+
+func getDispute(disputeID uint, ctx iris.Context) (*models.BillDispute, *models.Booking) {
+	dispute := loadDispute(disputeID, ctx)
+	if dispute == nil {
+		return nil, nil
+	}
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", dispute.BookingID).Find(&booking)
+	if bookingExists.Error != nil || bookingExists.RowsAffected == 0 {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	return dispute, &booking
+}
+
+// getDisputeForParty loads a dispute on a bill the caller is a party to and
+// returns the caller's role in it. The bill's specialist keeps access after
+// handing the booking over, and substitutes given a share of it get access.
+func getDisputeForParty(disputeID uint, ctx iris.Context) (*models.BillDispute, *models.Booking, string) {
+	dispute := loadDispute(disputeID, ctx)
+	if dispute == nil {
+		return nil, nil, ""
+	}
+
+	bill, booking, role := getBillForViewer(dispute.BillID, ctx)
+	if bill == nil {
+		return nil, nil, ""
+	}
+	return dispute, booking, role
+}
+
+// DisputeView is a dispute with the visits recorded in its bill's period,
+// the evidence of whether the service was given.
+type DisputeView struct {
+	models.BillDispute
+	Timesheet *Timesheet `json:"timesheet"`
+}
+
+type OpenDisputeInput struct {
+	BillID     uint   `json:"billID" validate:"required"`
+	ReasonCode string `json:"reasonCode" validate:"required,oneof=notReceived wrongAmount serviceNotProvided poorService duplicateCharge other"`
+	Reason     string `json:"reason" validate:"max=1024"`
+}
+
+type DisputeQuery struct {
+	DisputeID uint `url:"disputeId" validate:"required"`
+}
+
+type DisputeEvidenceInput struct {
+	DisputeID   uint   `json:"disputeID" validate:"required"`
+	Image       string `json:"image" validate:"required"`
+	Description string `json:"description" validate:"max=512"`
+}
+
+type DisputeMessageInput struct {
+	DisputeID uint   `json:"disputeID" validate:"required"`
+	Text      string `json:"text" validate:"required,max=2000"`
+}
+
+type ResolveDisputeInput struct {
+	DisputeID uint         `json:"disputeID" validate:"required"`
+	Outcome   string       `json:"outcome" validate:"required,oneof=refundFull refundPartial rejected"`
+	Amount    *money.Money `json:"amount"`
+	Note      string       `json:"note" validate:"max=1024"`
+}
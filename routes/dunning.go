@@ -0,0 +1,314 @@
+package routes
+
+import (
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ReminderKindDue     = "due"
+	ReminderKindOverdue = "overdue"
+	ReminderKindSummary = "specialistSummary"
+)
+
+const (
+	ReminderChannelPush  = "push"
+	ReminderChannelEmail = "email"
+)
+
+// overdueReason marks pauses made by dunning, so only those are lifted
+// automatically once the bill is settled.
+const overdueReason = "Paused because a bill is overdue."
+
+// RemindOutstandingBills works through unpaid bills. Reminders go out by push
+// and email on the days after billing listed in BILL_REMINDER_DAYS, bookings
+// become overdue after BILL_GRACE_DAYS, and overdue bookings are paused when
+// BILL_OVERDUE_PAUSES_BOOKING is true. Specialists get a daily summary of what
+// they are owed. It runs daily from the scheduler.
+func RemindOutstandingBills(report *JobReport) error {
+	schedule := utils.EnvIntList("BILL_REMINDER_DAYS", []int{0, 3, 7})
+	slices.Sort(schedule)
+	graceDays := utils.EnvInt("BILL_GRACE_DAYS", 10)
+	pauseOverdue := os.Getenv("BILL_OVERDUE_PAUSES_BOOKING") == "true"
+
+	var bills []models.Bill
+	// Bills under an open dispute wait for it to be resolved.
+	billsExist := storage.DB.
+		Where("paid = false AND complete = false").
+		Where("(dispute_status IS NULL OR dispute_status <> ?)", DisputeOpen).
+		Order("booking_id, created_at").
+		Find(&bills)
+	if billsExist.Error != nil {
+		return billsExist.Error
+	}
+
+	now := time.Now()
+	for _, bill := range bills {
+		var booking models.Booking
+		bookingExists := storage.DB.Where("id = ?", bill.BookingID).Find(&booking)
+		if bookingExists.Error != nil {
+			report.Fail(fmt.Errorf("bill %d: %w", bill.ID, bookingExists.Error))
+			continue
+		}
+		if bookingExists.RowsAffected == 0 {
+			continue
+		}
+
+		ageDays := int(now.Sub(bill.CreatedAt).Hours() / 24)
+		step := -1
+		for _, day := range schedule {
+			if ageDays >= day {
+				step = day
+			}
+		}
+		if step >= 0 {
+			sendBillReminder(bill, booking, step, report)
+		}
+		if ageDays >= graceDays {
+			markBookingOverdue(&booking, bill, pauseOverdue, report)
+		}
+	}
+
+	clearSettledOverdue(now.AddDate(0, 0, -graceDays), report)
+	sendOverdueSummaries(now, report)
+	return nil
+}
+
+// sendBillReminder sends the reminder for one step of the schedule. Missed
+// earlier steps are not caught up on, so a user never gets a burst of them.
+func sendBillReminder(bill models.Bill, booking models.Booking, step int, report *JobReport) {
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil || userExists.RowsAffected == 0 {
+		return
+	}
+
+	title := "Your specialist is waiting."
+	body := "Please pay your bill of " + bill.Amount.String() + " for uninterrupted service."
+	key := "bill:" + strconv.FormatUint(uint64(bill.ID), 10) + ":day" + strconv.Itoa(step)
+
+	if logReminder(key+":push", &bill.ID, user.ID, utils.RoleUser, ReminderKindDue, ReminderChannelPush) {
+		notifyPushTokens(user.PushTokens, user.AllowsNotifications, bookingPath(booking.ID), title, body)
+		report.Processed()
+	}
+	if user.Email != "" && logReminder(key+":email", &bill.ID, user.ID, utils.RoleUser, ReminderKindDue, ReminderChannelEmail) {
+		_, mailErr := utils.SendMail(user.Email, title, "<p>"+body+"</p>")
+		if mailErr != nil {
+			report.Fail(fmt.Errorf("bill %d reminder email: %w", bill.ID, mailErr))
+		}
+	}
+}
+
+func markBookingOverdue(booking *models.Booking, bill models.Bill, pauseOverdue bool, report *JobReport) {
+	if booking.Overdue {
+		return
+	}
+
+	transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		bookingOverdue := tx.Model(booking).Update("overdue", true)
+		if bookingOverdue.Error != nil {
+			return bookingOverdue.Error
+		}
+		if !pauseOverdue || booking.Status != BookingActive {
+			return nil
+		}
+		return transitionBooking(tx, booking, BookingPaused, 0, ActorSystem, overdueReason)
+	})
+	if transitionErr != nil {
+		report.Fail(fmt.Errorf("booking %d overdue: %w", booking.ID, transitionErr))
+		return
+	}
+	report.Processed()
+
+	body := "A bill for this booking is overdue."
+	if booking.Status == BookingPaused {
+		body = "A bill for this booking is overdue, so the booking is paused until it is paid."
+	}
+	key := "bill:" + strconv.FormatUint(uint64(bill.ID), 10) + ":overdue"
+	if logReminder(key, &bill.ID, booking.UserID, utils.RoleUser, ReminderKindOverdue, ReminderChannelPush) {
+		notifyBookingParty(booking, ActorSystem, "Payment overdue", body)
+	}
+}
+
+// overdueSuspensions are the windows dunning kept a booking paused for, as
+// accepted pauses, so the visits in them are not billed once it resumes. A
+// booking still suspended is paused with no end in sight.
+func overdueSuspensions(bookingID uint) ([]models.BookingChangeRequest, error) {
+	var history []models.BookingHistory
+	historyExists := storage.DB.
+		Where("booking_id = ? AND from_status <> to_status", bookingID).
+		Order("created_at, id").
+		Find(&history)
+	if historyExists.Error != nil {
+		return nil, historyExists.Error
+	}
+
+	var suspensions []models.BookingChangeRequest
+	var suspendedAt *time.Time
+	for _, change := range history {
+		if suspendedAt == nil && change.ToStatus == BookingPaused && change.ActorRole == ActorSystem && change.Reason == overdueReason {
+			suspendedAt = &change.CreatedAt
+			continue
+		}
+		if suspendedAt != nil && change.FromStatus == BookingPaused {
+			suspensions = append(suspensions, overdueSuspension(bookingID, *suspendedAt, change.CreatedAt))
+			suspendedAt = nil
+		}
+	}
+	if suspendedAt != nil {
+		suspensions = append(suspensions, overdueSuspension(bookingID, *suspendedAt, time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)))
+	}
+	return suspensions, nil
+}
+
+func overdueSuspension(bookingID uint, from time.Time, to time.Time) models.BookingChangeRequest {
+	return models.BookingChangeRequest{
+		BookingID:       bookingID,
+		Kind:            BookingChangePause,
+		Status:          BookingChangeAccepted,
+		RequestedByRole: ActorSystem,
+		Reason:          overdueReason,
+		PauseStart:      &from,
+		PauseEnd:        &to,
+	}
+}
+
+// clearSettledOverdue lifts the overdue flag from bookings that no longer
+// have a bill past its grace period, resuming those dunning paused.
+func clearSettledOverdue(graceCutoff time.Time, report *JobReport) {
+	var bookings []models.Booking
+	bookingsExist := storage.DB.
+		Where("overdue = true").
+		Where(`NOT EXISTS (SELECT 1 FROM bills WHERE bills.booking_id = bookings.id AND bills.paid = false
+		AND bills.complete = false AND bills.deleted_at IS NULL AND bills.created_at <= ?
+		AND (bills.dispute_status IS NULL OR bills.dispute_status <> ?))`, graceCutoff, DisputeOpen).
+		Find(&bookings)
+	if bookingsExist.Error != nil {
+		report.Fail(bookingsExist.Error)
+		return
+	}
+
+	for i := range bookings {
+		booking := &bookings[i]
+		transitionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+			bookingSettled := tx.Model(booking).Update("overdue", false)
+			if bookingSettled.Error != nil {
+				return bookingSettled.Error
+			}
+			if booking.Status != BookingPaused {
+				return nil
+			}
+
+			lastChange, err := lastStatusChange(tx, booking.ID)
+			if err != nil {
+				return err
+			}
+			if lastChange.ActorRole != ActorSystem || lastChange.Reason != overdueReason {
+				return nil
+			}
+			return transitionBooking(tx, booking, BookingActive, 0, ActorSystem, "")
+		})
+		if transitionErr != nil {
+			report.Fail(fmt.Errorf("booking %d settle: %w", booking.ID, transitionErr))
+			continue
+		}
+		report.Processed()
+		notifyBookingParty(booking, ActorSystem, "Payment settled", "This booking is no longer overdue.")
+	}
+}
+
+// sendOverdueSummaries tells each specialist once a day how much their
+// overdue bookings owe them. Amounts are summed per currency, and a booking
+// only ever bills in one.
+func sendOverdueSummaries(now time.Time, report *JobReport) {
+	var summaries []OverdueSummary
+	summariesExist := storage.DB.Table("bookings").
+		Select(`bills.specialist_id, COUNT(DISTINCT bookings.id) as booking_count,
+		COALESCE(SUM(bills.amount_minor), 0) as amount_minor, bills.amount_currency`).
+		Joins(`INNER JOIN bills ON bills.booking_id = bookings.id AND bills.paid = false
+		AND bills.complete = false AND bills.deleted_at IS NULL`).
+		Where("bookings.overdue = true AND bookings.deleted_at IS NULL").
+		Group("bills.specialist_id, bills.amount_currency").
+		Order("bills.specialist_id, bills.amount_currency").
+		Scan(&summaries)
+	if summariesExist.Error != nil {
+		report.Fail(summariesExist.Error)
+		return
+	}
+
+	var specialistIDs []uint
+	bySpecialist := make(map[uint][]OverdueSummary)
+	for _, summary := range summaries {
+		if _, seen := bySpecialist[summary.SpecialistID]; !seen {
+			specialistIDs = append(specialistIDs, summary.SpecialistID)
+		}
+		bySpecialist[summary.SpecialistID] = append(bySpecialist[summary.SpecialistID], summary)
+	}
+
+	for _, specialistID := range specialistIDs {
+		var specialist models.Specialist
+		specialistExists := storage.DB.Where("id = ?", specialistID).Find(&specialist)
+		if specialistExists.Error != nil || specialistExists.RowsAffected == 0 {
+			continue
+		}
+
+		bookingCount := 0
+		var amounts []string
+		for _, summary := range bySpecialist[specialistID] {
+			bookingCount += summary.BookingCount
+			amounts = append(amounts, summary.Amount.String())
+		}
+
+		title := "Overdue payments"
+		body := strconv.Itoa(bookingCount) + " of your bookings owe you " +
+			strings.Join(amounts, " and ") + " in overdue bills."
+		key := "summary:" + strconv.FormatUint(uint64(specialist.ID), 10) + ":" + now.Format(time.DateOnly)
+
+		if logReminder(key+":push", nil, specialist.ID, utils.RoleSpecialist, ReminderKindSummary, ReminderChannelPush) {
+			notifyPushTokens(specialist.PushTokens, specialist.AllowsNotifications, "tabs/bookingScreen/", title, body)
+			report.Processed()
+		}
+		if specialist.Email != "" && logReminder(key+":email", nil, specialist.ID, utils.RoleSpecialist, ReminderKindSummary, ReminderChannelEmail) {
+			_, mailErr := utils.SendMail(specialist.Email, title, "<p>"+body+"</p>")
+			if mailErr != nil {
+				report.Fail(fmt.Errorf("specialist %d summary email: %w", specialist.ID, mailErr))
+			}
+		}
+	}
+}
+
+// logReminder records a reminder under its key and reports whether it is new.
+// A reminder is logged before it is sent, so it goes out at most once.
+func logReminder(key string, billID *uint, recipientID uint, role string, kind string, channel string) bool {
+	reminder := models.ReminderLog{
+		Key:           key,
+		BillID:        billID,
+		RecipientID:   recipientID,
+		RecipientRole: role,
+		Kind:          kind,
+		Channel:       channel,
+	}
+	reminderLogged := storage.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&reminder)
+	return reminderLogged.Error == nil && reminderLogged.RowsAffected == 1
+}
+
+func bookingPath(bookingID uint) string {
+	return "tabs/bookingScreen/?bookingId=" + strconv.FormatUint(uint64(bookingID), 10)
+}
+
+type OverdueSummary struct {
+	SpecialistID uint
+	BookingCount int
+	Amount       money.Money `gorm:"embedded;embeddedPrefix:amount_"`
+}
@@ -0,0 +1,256 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errJobNotOffered       = errors.New("specialist does not offer this job")
+	errJobPostTaken        = errors.New("job post is no longer open")
+	errApplicationAnswered = errors.New("application was rejected or already hired")
+)
+
+// HireApplicant turns an application into a booking and a chat in one go and
+// marks the job post as filled.
+func HireApplicant(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req HireApplicantInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	var application models.JobApplication
+	applicationExists := storage.DB.Where("id = ?", req.ApplicationID).Find(&application)
+	if applicationExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if applicationExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	jobPost := getOwnedJobPost(id, application.JobPostID, ctx)
+	if jobPost == nil {
+		return
+	}
+
+	if !slices.Contains([]string{JobPostOpen, JobPostPaused}, jobPost.Status) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "A job post that is "+jobPost.Status+" cannot be hired for.", ctx)
+		return
+	}
+	if application.Status == ApplicationRejected {
+		utils.CreateError(iris.StatusConflict, "Conflict", "A rejected applicant cannot be hired.", ctx)
+		return
+	}
+
+	_, startErr := parseBookingDate(req.StartDate, time.UTC)
+	if startErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid startDate.", ctx)
+		return
+	}
+	scheduleErr := validateBookingSchedule(req.BookingScheduleInput)
+	if scheduleErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", scheduleErr.Error(), ctx)
+		return
+	}
+
+	// The specialist's application already stands for their acceptance.
+	now := time.Now()
+	booking := models.Booking{
+		UserID:       jobPost.UserID,
+		SpecialistID: application.SpecialistID,
+		JobPostID:    &jobPost.ID,
+		JobType:      jobPost.JobType,
+		Active:       false,
+		Status:       BookingAccepted,
+		RequestedAt:  &now,
+		AcceptedAt:   &now,
+		Frequency:    jobPost.WageFrequency,
+		Amount:       jobPost.Wage,
+		Overdue:      false,
+		StartDate:    req.StartDate,
+		EndDate:      req.EndDate,
+	}
+	applyBookingSchedule(&booking, req.BookingScheduleInput)
+	schedule, err := bookingOccurrenceSchedule(&booking)
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", err.Error(), ctx)
+		return
+	}
+
+	var chat models.Chat
+	var conflicts []AvailabilityConflict
+	hireErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// Filling the post first makes concurrent hires for it wait here and
+		// then find it taken.
+		jobPostFilled := tx.Model(&models.JobPost{}).
+			Where("id = ? AND status IN ?", jobPost.ID, []string{JobPostOpen, JobPostPaused}).
+			Update("status", JobPostFilled)
+		if jobPostFilled.Error != nil {
+			return jobPostFilled.Error
+		}
+		if jobPostFilled.RowsAffected == 0 {
+			return errJobPostTaken
+		}
+
+		applicationLocked := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", application.ID).Find(&application)
+		if applicationLocked.Error != nil {
+			return applicationLocked.Error
+		}
+		if application.Status == ApplicationRejected {
+			return errApplicationAnswered
+		}
+		var hired int64
+		hiredCounted := tx.Model(&models.Booking{}).
+			Where("job_post_id = ? AND specialist_id = ?", jobPost.ID, application.SpecialistID).
+			Count(&hired)
+		if hiredCounted.Error != nil {
+			return hiredCounted.Error
+		}
+		if hired > 0 {
+			return errApplicationAnswered
+		}
+
+		// With the post locked, no other hire for it can book the specialist
+		// between this check and the booking being created.
+		found, err := specialistConflicts(booking.SpecialistID, schedule, now, 0)
+		if err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			conflicts = found
+			return errSpecialistUnavailable
+		}
+
+		applicationAccepted := tx.Model(&application).Update("status", ApplicationAccepted)
+		if applicationAccepted.Error != nil {
+			return applicationAccepted.Error
+		}
+
+		policy, err := offeringCancellationPolicy(tx, booking.SpecialistID, booking.JobType)
+		if err != nil {
+			return err
+		}
+		booking.CancellationPolicy = policy
+		bookingCreated := tx.Create(&booking)
+		if bookingCreated.Error != nil {
+			return bookingCreated.Error
+		}
+		historyCreated := tx.Create(&models.BookingHistory{
+			BookingID: booking.ID,
+			ToStatus:  BookingAccepted,
+			ActorID:   jobPost.UserID,
+			ActorRole: utils.RoleUser,
+			Reason:    "Hired from job post",
+		})
+		if historyCreated.Error != nil {
+			return historyCreated.Error
+		}
+
+		var job models.Job
+		jobExists := tx.Where("specialist_id = ? AND job_name = ?", application.SpecialistID, jobPost.JobType).Find(&job)
+		if jobExists.Error != nil {
+			return jobExists.Error
+		}
+		if jobExists.RowsAffected == 0 {
+			return errJobNotOffered
+		}
+
+		chatExists := tx.
+			Where("user_id = ? AND specialist_id = ? AND job_id = ?", jobPost.UserID, application.SpecialistID, job.ID).
+			Find(&chat)
+		if chatExists.Error != nil {
+			return chatExists.Error
+		}
+
+		message := models.Message{
+			SenderID:   jobPost.UserID,
+			SenderRole: utils.RoleUser,
+			ReceiverID: application.SpecialistID,
+			Text:       req.Text,
+		}
+		if chatExists.RowsAffected > 0 {
+			message.ChatID = chat.ID
+			messageCreated := tx.Create(&message)
+			if messageCreated.Error != nil {
+				return messageCreated.Error
+			}
+		} else {
+			chat = models.Chat{
+				UserID:       jobPost.UserID,
+				SpecialistID: application.SpecialistID,
+				JobId:        job.ID,
+				Messages:     []models.Message{message},
+			}
+			chatCreated := tx.Create(&chat)
+			if chatCreated.Error != nil {
+				return chatCreated.Error
+			}
+		}
+
+		return nil
+	})
+	if errors.Is(hireErr, errJobNotOffered) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "The specialist no longer offers this type of job.", ctx)
+		return
+	}
+	if errors.Is(hireErr, errJobPostTaken) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This job post was filled in the meantime.", ctx)
+		return
+	}
+	if errors.Is(hireErr, errSpecialistUnavailable) {
+		ctx.StopWithProblem(
+			iris.StatusConflict,
+			iris.NewProblem().Title("Conflict").Detail(errSpecialistUnavailable.Error()).Key("clashes", requestedClashes(conflicts)))
+		return
+	}
+	if errors.Is(hireErr, errApplicationAnswered) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This applicant can no longer be hired.", ctx)
+		return
+	}
+	if hireErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	bookingID := strconv.FormatUint(uint64(booking.ID), 10)
+	notifySpecialist(
+		application.SpecialistID,
+		"tabs/bookingScreen/?bookingId="+bookingID,
+		"You've been hired",
+		"You were hired for "+jobPost.Title+".",
+	)
+	notifyUser(
+		jobPost.UserID,
+		"tabs/bookingScreen/?bookingId="+bookingID,
+		"Booking created",
+		"Your booking for "+jobPost.Title+" was created and the post is now filled.",
+	)
+
+	ctx.JSON(iris.Map{
+		"booking": booking,
+		"chat":    chat,
+	})
+}
+
+type HireApplicantInput struct {
+	BookingScheduleInput
+	ApplicationID uint   `json:"applicationID" validate:"required"`
+	StartDate     string `json:"startDate" validate:"required"`
+	EndDate       string `json:"endDate"`
+	Text          string `json:"text" validate:"required,lt=5000"`
+}
@@ -0,0 +1,254 @@
+package routes
+
+import (
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"strconv"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	ApplicationApplied     = "applied"
+	ApplicationShortlisted = "shortlisted"
+	ApplicationRejected    = "rejected"
+	ApplicationAccepted    = "accepted"
+)
+
+// Statuses the poster may move an application to, keyed by its current status.
+var applicationTransitions = map[string][]string{
+	ApplicationApplied:     {ApplicationShortlisted, ApplicationRejected, ApplicationAccepted},
+	ApplicationShortlisted: {ApplicationRejected, ApplicationAccepted},
+}
+
+func ApplyToJobPost(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req ApplyToJobPostInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	jobPost := getJobPostByID(req.JobPostID, ctx)
+	if jobPost == nil {
+		return
+	}
+	if jobPost.Status != JobPostOpen {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This job post is no longer accepting applications.", ctx)
+		return
+	}
+
+	var job models.Job
+	jobExists := storage.DB.Where("specialist_id = ? AND job_name = ?", specialist.ID, jobPost.JobType).Find(&job)
+	if jobExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if jobExists.RowsAffected == 0 {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "You do not offer this type of job.", ctx)
+		return
+	}
+
+	var prevApplication models.JobApplication
+	applicationExists := storage.DB.Where("job_post_id = ? AND specialist_id = ?", jobPost.ID, specialist.ID).Find(&prevApplication)
+	if applicationExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if applicationExists.RowsAffected > 0 {
+		utils.CreateConflict(ctx)
+		return
+	}
+
+	if req.Rate.Currency != jobPost.Wage.Currency || !req.Rate.IsPositive() {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "The rate must be a positive amount in "+jobPost.Wage.Currency+".", ctx)
+		return
+	}
+
+	application := models.JobApplication{
+		JobPostID:    jobPost.ID,
+		SpecialistID: specialist.ID,
+		Message:      req.Message,
+		Rate:         req.Rate,
+		Status:       ApplicationApplied,
+	}
+	applicationCreated := storage.DB.Create(&application)
+	if applicationCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifyUser(
+		jobPost.UserID,
+		"screens/jobPost/ApplicationsScreen?jobPostId="+strconv.FormatUint(uint64(jobPost.ID), 10),
+		"New application",
+		specialist.FirstName+" applied to "+jobPost.Title+".",
+	)
+
+	ctx.JSON(application)
+}
+
+func GetApplicationsByJobPostID(ctx iris.Context) {
+	id := ctx.URLParam("id")
+	jobPostID, parseErr := ctx.URLParamInt("jobPostId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid jobPostId.", ctx)
+		return
+	}
+
+	jobPost := getJobPostByID(uint(jobPostID), ctx)
+	if jobPost == nil {
+		return
+	}
+	if strconv.FormatUint(uint64(jobPost.UserID), 10) != id {
+		utils.CreateForbidden(ctx)
+		return
+	}
+
+	var results []ApplicationResult
+	resultQuery := storage.DB.Table("job_applications").
+		Select(`job_applications.*,
+		specialists.first_name as specialist_first_name, specialists.last_name as specialist_last_name,
+		specialists.avatar as specialist_avatar, specialists.experience as specialist_experience, specialists.stars as specialist_stars`).
+		Joins("INNER JOIN specialists on job_applications.specialist_id = specialists.id").
+		Where("job_applications.job_post_id = ? AND job_applications.deleted_at IS NULL", jobPost.ID).
+		Order("job_applications.created_at DESC").
+		Scan(&results)
+
+	if resultQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(results)
+}
+
+func GetApplicationsBySpecialistID(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var results []SpecialistApplicationResult
+	resultQuery := storage.DB.Table("job_applications").
+		Select(`job_applications.*,
+		job_posts.title as job_post_title, job_posts.job_type as job_post_job_type,
+		job_posts.wage_minor as job_post_wage_minor, job_posts.wage_currency as job_post_wage_currency,
+		job_posts.wage_frequency as job_post_wage_frequency`).
+		Joins("INNER JOIN job_posts on job_applications.job_post_id = job_posts.id").
+		Where("job_applications.specialist_id = ? AND job_applications.deleted_at IS NULL", id).
+		Order("job_applications.created_at DESC").
+		Scan(&results)
+
+	if resultQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(results)
+}
+
+func UpdateApplicationStatus(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req UpdateApplicationStatusInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	var application models.JobApplication
+	applicationExists := storage.DB.Where("id = ?", req.ApplicationID).Find(&application)
+	if applicationExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if applicationExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	jobPost := getJobPostByID(application.JobPostID, ctx)
+	if jobPost == nil {
+		return
+	}
+	if strconv.FormatUint(uint64(jobPost.UserID), 10) != id {
+		utils.CreateForbidden(ctx)
+		return
+	}
+
+	if !slices.Contains(applicationTransitions[application.Status], req.Status) {
+		utils.CreateError(
+			iris.StatusConflict,
+			"Conflict",
+			"An application that is "+application.Status+" cannot be "+req.Status+".",
+			ctx,
+		)
+		return
+	}
+
+	application.Status = req.Status
+	rowsUpdated := storage.DB.Model(&application).Update("status", req.Status)
+	if rowsUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifySpecialist(
+		application.SpecialistID,
+		"screens/jobPost/MyApplicationsScreen",
+		"Application "+req.Status,
+		"Your application to "+jobPost.Title+" was "+req.Status+".",
+	)
+
+	ctx.JSON(application)
+}
+
+func getJobPostByID(id uint, ctx iris.Context) *models.JobPost {
+	var jobPost models.JobPost
+	jobPostExists := storage.DB.Where("id = ?", id).Find(&jobPost)
+
+	if jobPostExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	if jobPostExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+	return &jobPost
+}
+
+type ApplicationResult struct {
+	models.JobApplication
+	SpecialistFirstName  string `json:"specialistFirstName"`
+	SpecialistLastName   string `json:"specialistLastName"`
+	SpecialistAvatar     string `json:"specialistAvatar"`
+	SpecialistExperience int    `json:"specialistExperience"`
+	SpecialistStars      int    `json:"specialistStars"`
+}
+
+type SpecialistApplicationResult struct {
+	models.JobApplication
+	JobPostTitle         string      `json:"jobPostTitle"`
+	JobPostJobType       string      `json:"jobPostJobType"`
+	JobPostWage          money.Money `json:"jobPostWage" gorm:"embedded;embeddedPrefix:job_post_wage_"`
+	JobPostWageFrequency string      `json:"jobPostWageFrequency"`
+}
+
+type ApplyToJobPostInput struct {
+	JobPostID uint        `json:"jobPostID" validate:"required"`
+	Message   string      `json:"message" validate:"required,max=1024"`
+	Rate      money.Money `json:"rate"`
+}
+
+type UpdateApplicationStatusInput struct {
+	ApplicationID uint   `json:"applicationID" validate:"required"`
+	Status        string `json:"status" validate:"required,oneof=shortlisted rejected accepted"`
+}
@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	defaultBoardLimit = 20
+	maxBoardLimit     = 50
+)
+
+func GetJobBoard(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var filter JobBoardFilter
+	err := ctx.ReadQuery(&filter)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	if (filter.MinWage > 0 || filter.MaxWage > 0) && filter.WageCurrency == "" {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Wage bounds need a wageCurrency.", ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultBoardLimit
+	}
+	if limit > maxBoardLimit {
+		limit = maxBoardLimit
+	}
+
+	lat := float64(specialist.Lat)
+	lon := float64(specialist.Lon)
+	distance := utils.DistanceSQL("users.lat", "users.lon")
+
+	appliedQuery := storage.DB.Model(&models.JobApplication{}).Select("job_post_id").Where("specialist_id = ?", specialist.ID)
+	dismissedQuery := storage.DB.Model(&models.JobPostDismissal{}).Select("job_post_id").Where("specialist_id = ?", specialist.ID)
+
+	query := storage.DB.Table("job_posts").
+		Select(`job_posts.*,
+		users.first_name as user_first_name, users.last_name as user_last_name, users.avatar as user_avatar, users.city as user_city,
+		`+distance+` as distance_km`, lat, lon, lat).
+		Joins("INNER JOIN users on job_posts.user_id = users.id").
+		Where("job_posts.deleted_at IS NULL AND job_posts.status = ? AND job_posts.expires_at > ?", JobPostOpen, time.Now()).
+		Where("job_posts.id NOT IN (?)", appliedQuery).
+		Where("job_posts.id NOT IN (?)", dismissedQuery)
+
+	if filter.JobType != "" {
+		query = query.Where("job_posts.job_type = ?", filter.JobType)
+	}
+	if filter.WageFrequency != "" {
+		query = query.Where("job_posts.wage_frequency = ?", filter.WageFrequency)
+	}
+	// Wage bounds are in minor units of WageCurrency, so they only match
+	// posts paying in it.
+	if filter.WageCurrency != "" {
+		query = query.Where("job_posts.wage_currency = ?", filter.WageCurrency)
+	}
+	if filter.MinWage > 0 {
+		query = query.Where("job_posts.wage_minor >= ?", filter.MinWage)
+	}
+	if filter.MaxWage > 0 {
+		query = query.Where("job_posts.wage_minor <= ?", filter.MaxWage)
+	}
+	if filter.RadiusKm > 0 {
+		query = query.Where(distance+" <= ?", lat, lon, lat, filter.RadiusKm)
+	}
+	if !filter.PostedAfter.IsZero() {
+		query = query.Where("job_posts.created_at >= ?", filter.PostedAfter)
+	}
+	if !filter.PostedBefore.IsZero() {
+		query = query.Where("job_posts.created_at <= ?", filter.PostedBefore)
+	}
+	if filter.Cursor > 0 {
+		query = query.Where("job_posts.id < ?", filter.Cursor)
+	}
+
+	var results []JobBoardResult
+	resultQuery := query.Order("job_posts.id DESC").Limit(limit).Scan(&results)
+	if resultQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	// Converted wages are approximate and only meant for display.
+	if filter.DisplayCurrency != "" {
+		for index, result := range results {
+			converted, err := money.Convert(result.Wage, filter.DisplayCurrency)
+			if err == nil {
+				results[index].DisplayWage = &converted
+			}
+		}
+	}
+
+	var nextCursor uint
+	if len(results) == limit {
+		nextCursor = results[len(results)-1].ID
+	}
+
+	ctx.JSON(iris.Map{
+		"jobPosts":   results,
+		"nextCursor": nextCursor,
+	})
+}
+
+func DismissJobPost(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req DismissJobPostInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	jobPost := getJobPostByID(req.JobPostID, ctx)
+	if jobPost == nil {
+		return
+	}
+
+	dismissal := models.JobPostDismissal{
+		JobPostID:    jobPost.ID,
+		SpecialistID: specialist.ID,
+	}
+	dismissalCreated := storage.DB.Where(&dismissal).FirstOrCreate(&dismissal)
+	if dismissalCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+type JobBoardFilter struct {
+	JobType         string    `url:"jobType" validate:"omitempty,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
+	WageFrequency   string    `url:"wageFrequency" validate:"omitempty,oneof=monthly daily"`
+	WageCurrency    string    `url:"wageCurrency" validate:"omitempty,currency"`
+	MinWage         int64     `url:"minWage" validate:"gte=0"`
+	MaxWage         int64     `url:"maxWage" validate:"gte=0"`
+	DisplayCurrency string    `url:"displayCurrency" validate:"omitempty,currency"`
+	RadiusKm        float64   `url:"radiusKm" validate:"gte=0"`
+	PostedAfter     time.Time `url:"postedAfter"`
+	PostedBefore    time.Time `url:"postedBefore"`
+	Cursor          uint      `url:"cursor"`
+	Limit           int       `url:"limit" validate:"gte=0"`
+}
+
+type JobBoardResult struct {
+	models.JobPost
+	UserFirstName string       `json:"userFirstName"`
+	UserLastName  string       `json:"userLastName"`
+	UserAvatar    string       `json:"userAvatar"`
+	UserCity      string       `json:"userCity"`
+	DistanceKm    float64      `json:"distanceKm"`
+	DisplayWage   *money.Money `json:"displayWage,omitempty" gorm:"-"`
+}
+
+type DismissJobPostInput struct {
+	JobPostID uint `json:"jobPostID" validate:"required"`
+}
@@ -1,14 +1,39 @@
 package routes
 
 import (
+	"fmt"
 	"jotno-server/models"
+	"jotno-server/money"
 	"jotno-server/storage"
 	"jotno-server/utils"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+const (
+	JobPostOpen    = "open"
+	JobPostPaused  = "paused"
+	JobPostFilled  = "filled"
+	JobPostExpired = "expired"
+	JobPostDeleted = "deleted"
+)
+
+const jobPostLifetime = 30 * 24 * time.Hour
+
+// Statuses the owner may move a job post to, keyed by its current status.
+// Expired posts can be reopened together with a new expiry.
+var jobPostTransitions = map[string][]string{
+	JobPostOpen:    {JobPostPaused, JobPostFilled},
+	JobPostPaused:  {JobPostOpen, JobPostFilled},
+	JobPostExpired: {JobPostOpen},
+}
+
 func CreateJobPosts(ctx iris.Context) {
 	var jobPostInput CreateJobPostInput
 
@@ -17,17 +42,35 @@ func CreateJobPosts(ctx iris.Context) {
 		utils.ValidationError(err, ctx)
 		return
 	}
+	if !jobPostInput.Wage.IsPositive() {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "A job post needs a wage.", ctx)
+		return
+	}
+	expiresAt := time.Now().Add(jobPostLifetime)
+	if jobPostInput.ExpiresAt != nil {
+		if !jobPostInput.ExpiresAt.After(time.Now()) {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "A job post needs an expiry in the future.", ctx)
+			return
+		}
+		expiresAt = *jobPostInput.ExpiresAt
+	}
 	jobPost := models.JobPost{
-		UserID:        jobPostInput.UserID,
+		UserID:        jwt.Get(ctx).(*utils.AccessToken).ID,
 		JobType:       jobPostInput.JobType,
 		Title:         jobPostInput.Title,
 		Description:   jobPostInput.Description,
 		Wage:          jobPostInput.Wage,
-		WageCurrency:  "BDT",
 		WageFrequency: jobPostInput.WageFrequency,
 		DateTime:      jobPostInput.DateTime,
+		Status:        JobPostOpen,
+		ExpiresAt:     expiresAt,
+	}
+	jobPostCreated := storage.DB.Create(&jobPost)
+	if jobPostCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
 	}
-	storage.DB.Create(&jobPost)
+	EnqueueJobPostFanOut(jobPost.ID)
 	ctx.JSON(jobPost)
 }
 
@@ -35,24 +78,206 @@ func GetJobsPostsByUserID(ctx iris.Context) {
 	id := ctx.URLParam("id")
 
 	var jobPosts []models.JobPost
-	jobPostsExists := storage.DB.Preload(clause.Associations).Where("user_id = ?", id).Find(&jobPosts)
+	jobPostsExists := storage.DB.Preload(clause.Associations).Where("user_id = ? AND status <> ?", id, JobPostDeleted).Find(&jobPosts)
 	if jobPostsExists.Error != nil {
 		return
 	}
+
+	var jobPostIDs []uint
+	for _, jobPost := range jobPosts {
+		jobPostIDs = append(jobPostIDs, jobPost.ID)
+	}
+
+	var applicantCounts []ApplicantCount
+	countsQuery := storage.DB.Model(&models.JobApplication{}).
+		Select("job_post_id, COUNT(*) AS count").
+		Where("job_post_id IN ?", jobPostIDs).
+		Group("job_post_id").
+		Scan(&applicantCounts)
+	if countsQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	countMap := make(map[uint]int64)
+	for _, applicantCount := range applicantCounts {
+		countMap[applicantCount.JobPostID] = applicantCount.Count
+	}
+	for index, jobPost := range jobPosts {
+		jobPosts[index].ApplicantCount = countMap[jobPost.ID]
+	}
+
 	ctx.JSON(jobPosts)
 }
 
+func UpdateJobPost(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req UpdateJobPostInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	jobPost := getOwnedJobPost(id, req.JobPostID, ctx)
+	if jobPost == nil {
+		return
+	}
+
+	// Only live posts can be edited. An expired post can be, as it is
+	// reopened.
+	status := jobPost.Status
+	if !slices.Contains([]string{JobPostOpen, JobPostPaused}, status) && !(status == JobPostExpired && req.Status == JobPostOpen) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "A job post that is "+status+" cannot be edited.", ctx)
+		return
+	}
+
+	changes := map[string]interface{}{}
+	if req.Title != "" {
+		jobPost.Title = req.Title
+		changes["title"] = jobPost.Title
+	}
+	if req.Description != "" {
+		jobPost.Description = req.Description
+		changes["description"] = jobPost.Description
+	}
+	if req.Wage != nil && req.Wage.IsPositive() {
+		jobPost.Wage = *req.Wage
+		changes["wage_minor"] = jobPost.Wage.Minor
+		changes["wage_currency"] = jobPost.Wage.Currency
+	}
+	if req.WageFrequency != "" {
+		jobPost.WageFrequency = req.WageFrequency
+		changes["wage_frequency"] = jobPost.WageFrequency
+	}
+	if req.DateTime != "" {
+		jobPost.DateTime = req.DateTime
+		changes["date_time"] = jobPost.DateTime
+	}
+	if req.ExpiresAt != nil {
+		jobPost.ExpiresAt = *req.ExpiresAt
+		changes["expires_at"] = jobPost.ExpiresAt
+	}
+
+	if req.Status != "" && req.Status != jobPost.Status {
+		if !slices.Contains(jobPostTransitions[jobPost.Status], req.Status) {
+			utils.CreateError(
+				iris.StatusConflict,
+				"Conflict",
+				"A job post that is "+jobPost.Status+" cannot be "+req.Status+".",
+				ctx,
+			)
+			return
+		}
+		jobPost.Status = req.Status
+		changes["status"] = jobPost.Status
+	}
+
+	if jobPost.Status == JobPostOpen && !jobPost.ExpiresAt.After(time.Now()) {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "An open job post needs an expiry in the future.", ctx)
+		return
+	}
+
+	if len(changes) == 0 {
+		ctx.JSON(jobPost)
+		return
+	}
+
+	// A hire or the expiry job may have moved the post on since it was read.
+	rowsUpdated := storage.DB.Model(jobPost).Where("status = ?", status).Updates(changes)
+	if rowsUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if rowsUpdated.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "The job post changed in the meantime.", ctx)
+		return
+	}
+	ctx.JSON(jobPost)
+}
+
+// DeleteJobPost removes a job post. Posts with accepted applicants are only
+// soft-closed so those specialists keep seeing what they were hired for.
 func DeleteJobPost(ctx iris.Context) {
-	id := ctx.URLParam("jobId")
+	id := ctx.URLParam("id")
+	jobPostID, parseErr := ctx.URLParamInt("jobId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid jobId.", ctx)
+		return
+	}
 
-	jobPostsDeleted := storage.DB.Delete(&models.JobPost{}, id)
-	if jobPostsDeleted.Error != nil {
-		utils.CreateError(iris.StatusInternalServerError, "Error", jobPostsDeleted.Error.Error(), ctx)
+	jobPost := getOwnedJobPost(id, uint(jobPostID), ctx)
+	if jobPost == nil {
+		return
+	}
+
+	var acceptedCount int64
+	acceptedQuery := storage.DB.Model(&models.JobApplication{}).
+		Where("job_post_id = ? AND status = ?", jobPost.ID, ApplicationAccepted).
+		Count(&acceptedCount)
+	if acceptedQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	jobPostsDeleted := storage.DB.Transaction(func(tx *gorm.DB) error {
+		statusUpdated := tx.Model(jobPost).Update("status", JobPostDeleted)
+		if statusUpdated.Error != nil {
+			return statusUpdated.Error
+		}
+		if acceptedCount > 0 {
+			return nil
+		}
+		return tx.Delete(jobPost).Error
+	})
+	if jobPostsDeleted != nil {
+		utils.CreateError(iris.StatusInternalServerError, "Error", jobPostsDeleted.Error(), ctx)
 		return
 	}
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+// ExpireJobPosts marks open and paused posts past their expiry as expired and
+// lets their owners know. It runs from the scheduler.
+func ExpireJobPosts(report *JobReport) error {
+	var jobPosts []models.JobPost
+	jobPostsExists := storage.DB.
+		Where("status IN ? AND expires_at <= ?", []string{JobPostOpen, JobPostPaused}, time.Now()).
+		Find(&jobPosts)
+	if jobPostsExists.Error != nil {
+		return jobPostsExists.Error
+	}
+
+	for _, jobPost := range jobPosts {
+		jobPostExpired := storage.DB.Model(&jobPost).Update("status", JobPostExpired)
+		if jobPostExpired.Error != nil {
+			report.Fail(fmt.Errorf("job post %d: %w", jobPost.ID, jobPostExpired.Error))
+			continue
+		}
+		report.Processed()
+		notifyUser(
+			jobPost.UserID,
+			"screens/jobPost/JobPostScreen?jobPostId="+strconv.FormatUint(uint64(jobPost.ID), 10),
+			"Job post expired",
+			jobPost.Title+" has expired. Reopen it to keep receiving applications.",
+		)
+	}
+	return nil
+}
+
+func getOwnedJobPost(userID string, jobPostID uint, ctx iris.Context) *models.JobPost {
+	jobPost := getJobPostByID(jobPostID, ctx)
+	if jobPost == nil {
+		return nil
+	}
+	if strconv.FormatUint(uint64(jobPost.UserID), 10) != userID {
+		utils.CreateForbidden(ctx)
+		return nil
+	}
+	return jobPost
+}
+
 func GetCommentsByJobPostID(ctx iris.Context) {
 	id := ctx.URLParam("id")
 
@@ -67,11 +292,27 @@ func GetCommentsByJobPostID(ctx iris.Context) {
 }
 
 type CreateJobPostInput struct {
-	JobType       string `json:"jobType" validate:"required,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
-	Title         string `json:"title" validate:"required,max=256"`
-	Description   string `json:"description" validate:"required,max=512"`
-	Wage          int    `json:"wage" validate:"required"`
-	WageFrequency string `json:"wageFrequency" validate:"required,oneof=monthly daily"`
-	DateTime      string `json:"dateTime" validate:"required,max=20"`
-	UserID        uint   `json:"userID" validate:"required"`
+	JobType       string      `json:"jobType" validate:"required,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
+	Title         string      `json:"title" validate:"required,max=256"`
+	Description   string      `json:"description" validate:"required,max=512"`
+	Wage          money.Money `json:"wage"`
+	WageFrequency string      `json:"wageFrequency" validate:"required,oneof=monthly daily"`
+	DateTime      string      `json:"dateTime" validate:"required,max=20"`
+	ExpiresAt     *time.Time  `json:"expiresAt"`
+}
+
+type UpdateJobPostInput struct {
+	JobPostID     uint         `json:"jobPostID" validate:"required"`
+	Title         string       `json:"title" validate:"max=256"`
+	Description   string       `json:"description" validate:"max=512"`
+	Wage          *money.Money `json:"wage"`
+	WageFrequency string       `json:"wageFrequency" validate:"omitempty,oneof=monthly daily"`
+	DateTime      string       `json:"dateTime" validate:"max=20"`
+	ExpiresAt     *time.Time   `json:"expiresAt"`
+	Status        string       `json:"status" validate:"omitempty,oneof=open paused filled"`
+}
+
+type ApplicantCount struct {
+	JobPostID uint
+	Count     int64
 }
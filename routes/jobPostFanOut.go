@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"context"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"log"
+	"strconv"
+	"time"
+)
+
+const jobPostFanOutQueue = "jobPost:fanOut"
+
+// EnqueueJobPostFanOut queues a new job post so nearby specialists can be told
+// about it without holding up the request that created it.
+func EnqueueJobPostFanOut(jobPostID uint) {
+	err := storage.Redis.LPush(context.Background(), jobPostFanOutQueue, jobPostID).Err()
+	if err != nil {
+		log.Println("could not queue job post fan-out:", jobPostID, err)
+	}
+}
+
+// StartJobPostFanOutWorker consumes the fan-out queue until the process exits.
+func StartJobPostFanOutWorker() {
+	go func() {
+		for {
+			result, err := storage.Redis.BRPop(context.Background(), 0, jobPostFanOutQueue).Result()
+			if err != nil {
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			jobPostID, parseErr := strconv.ParseUint(result[1], 10, 32)
+			if parseErr != nil {
+				continue
+			}
+			fanOutErr := fanOutJobPost(uint(jobPostID))
+			if fanOutErr != nil {
+				log.Println("job post fan-out failed:", jobPostID, fanOutErr)
+			}
+		}
+	}()
+}
+
+func fanOutJobPost(jobPostID uint) error {
+	var jobPost models.JobPost
+	jobPostExists := storage.DB.Where("id = ? AND status = ?", jobPostID, JobPostOpen).Find(&jobPost)
+	if jobPostExists.Error != nil || jobPostExists.RowsAffected == 0 {
+		return jobPostExists.Error
+	}
+
+	var user models.User
+	userExists := storage.DB.Where("id = ?", jobPost.UserID).Find(&user)
+	if userExists.Error != nil || userExists.RowsAffected == 0 {
+		return userExists.Error
+	}
+
+	alerted := matchSavedSearches(&jobPost, &user)
+
+	radiusKm := utils.EnvFloat("JOB_POST_NOTIFY_RADIUS_KM", 10)
+	lat := float64(user.Lat)
+	lon := float64(user.Lon)
+
+	var specialists []models.Specialist
+	subQuery := storage.DB.Select("specialist_id").Where("job_name = ?", jobPost.JobType).Table("jobs")
+	specialistsExist := storage.DB.
+		Where("id IN (?) AND allows_notifications IS NOT FALSE", subQuery).
+		Where(utils.DistanceSQL("lat", "lon")+" <= ?", lat, lon, lat, radiusKm).
+		Find(&specialists)
+	if specialistsExist.Error != nil {
+		return specialistsExist.Error
+	}
+
+	path := "screens/jobPost/JobPostScreen?jobPostId=" + strconv.FormatUint(uint64(jobPost.ID), 10)
+	for _, specialist := range specialists {
+		if alerted[specialist.ID] || specialist.PushTokens == nil || !withinJobPostDailyCap(specialist.ID) {
+			continue
+		}
+		notifyPushTokens(specialist.PushTokens, specialist.AllowsNotifications, path, "New job nearby", jobPost.Title)
+	}
+	return nil
+}
+
+// withinJobPostDailyCap counts a job post notification against the
+// specialist's daily allowance and reports whether it may still be sent.
+func withinJobPostDailyCap(specialistID uint) bool {
+	key := "jobPost:notified:" + strconv.FormatUint(uint64(specialistID), 10) + ":" + time.Now().Format("2006-01-02")
+	count, err := storage.Redis.Incr(context.Background(), key).Result()
+	if err != nil {
+		return false
+	}
+	if count == 1 {
+		storage.Redis.Expire(context.Background(), key, 24*time.Hour)
+	}
+	return count <= int64(utils.EnvInt("JOB_POST_NOTIFY_DAILY_CAP", 5))
+}
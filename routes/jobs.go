@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	JobBilling           = "billing"
+	JobBillReminders     = "billReminders"
+	JobExpireJobPosts    = "expireJobPosts"
+	JobActivateBookings  = "activateBookings"
+	JobAlertDigests      = "jobAlertDigests"
+	JobCashDisputes      = "cashDisputes"
+	JobBookingPauses     = "bookingPauses"
+	JobDuplicatePayments = "duplicatePayments"
+)
+
+const (
+	JobTriggerScheduled = "scheduled"
+	JobTriggerManual    = "manual"
+)
+
+const (
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+const (
+	jobLeaseTTL            = 30 * time.Minute
+	jobLeaseRenewal        = 10 * time.Minute
+	maxJobRunErrorsStored  = 20
+	defaultJobRunListLimit = 50
+	maxJobRunListLimit     = 200
+)
+
+var errJobLeaseHeld = errors.New("job is already running")
+
+// JobReport collects what a scheduled job did for its run ledger entry.
+type JobReport struct {
+	processed int
+	errors    []error
+}
+
+func (report *JobReport) Processed() {
+	report.processed++
+}
+
+func (report *JobReport) Fail(err error) {
+	report.errors = append(report.errors, err)
+}
+
+var scheduledJobs = map[string]func(*JobReport) error{
+	JobBilling:           RunBilling,
+	JobBillReminders:     RemindOutstandingBills,
+	JobExpireJobPosts:    ExpireJobPosts,
+	JobActivateBookings:  ActivateBookings,
+	JobAlertDigests:      SendJobAlertDigests,
+	JobCashDisputes:      OpenCashDisputes,
+	JobBookingPauses:     ApplyBookingPauses,
+	JobDuplicatePayments: RefundDuplicatePayments,
+}
+
+// ScheduledTask wraps a job for the scheduler. Every server process schedules
+// it, but only the one holding the job's lease runs it.
+func ScheduledTask(name string) func() error {
+	return func() error {
+		run, release, err := beginJobRun(name, JobTriggerScheduled)
+		if errors.Is(err, errJobLeaseHeld) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer release()
+		return executeJobRun(run)
+	}
+}
+
+func GetJobRuns(ctx iris.Context) {
+	name := ctx.URLParam("name")
+	limit := ctx.URLParamIntDefault("limit", defaultJobRunListLimit)
+	if limit <= 0 {
+		limit = defaultJobRunListLimit
+	}
+	if limit > maxJobRunListLimit {
+		limit = maxJobRunListLimit
+	}
+
+	query := storage.DB.Order("started_at DESC").Limit(limit)
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+
+	var jobRuns []models.JobRun
+	jobRunsExist := query.Find(&jobRuns)
+	if jobRunsExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(jobRuns)
+}
+
+func TriggerJobRun(ctx iris.Context) {
+	var req TriggerJobRunInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	if _, ok := scheduledJobs[req.Name]; !ok {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	run, release, err := beginJobRun(req.Name, JobTriggerManual)
+	if errors.Is(err, errJobLeaseHeld) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This job is already running.", ctx)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	go func() {
+		defer release()
+		executeJobRun(run)
+	}()
+
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(run)
+}
+
+// beginJobRun takes the job's lease and opens its ledger entry. The lease is
+// renewed for as long as the run lasts, and the returned function releases
+// it once the run is over.
+func beginJobRun(name string, trigger string) (*models.JobRun, func(), error) {
+	leaseKey := "job:lease:" + name
+	token, err := storage.AcquireLease(leaseKey, jobLeaseTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == "" {
+		return nil, nil, errJobLeaseHeld
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed, renewErr := storage.RenewLease(leaseKey, token, jobLeaseTTL)
+				if renewErr != nil {
+					log.Println("could not renew job lease:", name, renewErr)
+					continue
+				}
+				if !renewed {
+					log.Println("job lease was lost while running:", name)
+					return
+				}
+			}
+		}
+	}()
+	release := func() {
+		close(done)
+		releaseErr := storage.ReleaseLease(leaseKey, token)
+		if releaseErr != nil {
+			log.Println("could not release job lease:", name, releaseErr)
+		}
+	}
+
+	run := models.JobRun{
+		Name:      name,
+		Trigger:   trigger,
+		Status:    JobRunRunning,
+		StartedAt: time.Now(),
+	}
+	runCreated := storage.DB.Create(&run)
+	if runCreated.Error != nil {
+		release()
+		return nil, nil, runCreated.Error
+	}
+	return &run, release, nil
+}
+
+func executeJobRun(run *models.JobRun) error {
+	report := JobReport{}
+	jobErr := scheduledJobs[run.Name](&report)
+	if jobErr != nil {
+		report.Fail(jobErr)
+	}
+
+	var messages []string
+	for i, err := range report.errors {
+		if i == maxJobRunErrorsStored {
+			break
+		}
+		messages = append(messages, err.Error())
+	}
+
+	endedAt := time.Now()
+	run.EndedAt = &endedAt
+	run.ItemsProcessed = report.processed
+	run.ErrorCount = len(report.errors)
+	run.Errors = strings.Join(messages, "\n")
+	run.Status = JobRunSucceeded
+	if jobErr != nil {
+		run.Status = JobRunFailed
+	}
+
+	runSaved := storage.DB.Save(run)
+	if runSaved.Error != nil {
+		log.Println("could not record job run:", run.Name, runSaved.Error)
+	}
+	return jobErr
+}
+
+// retry calls fn until it succeeds or runs out of attempts, doubling the delay
+// between attempts.
+func retry(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if attempt < attempts-1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+type TriggerJobRunInput struct {
+	Name string `json:"name" validate:"required"`
+}
@@ -0,0 +1,335 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"strconv"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ledger accounts. Amounts are debits when positive and credits when
+// negative, and every transaction sums to zero.
+const (
+	AccountUserPayable          = "userPayable"
+	AccountSpecialistReceivable = "specialistReceivable"
+	AccountPlatformRevenue      = "platformRevenue"
+	AccountPlatformCash         = "platformCash"
+	AccountRefunds              = "refunds"
+	AccountSubstitutions        = "substitutions"
+)
+
+const (
+	LedgerBillCreated = "billCreated"
+	LedgerBillPaid    = "billPaid"
+	LedgerRefund      = "refund"
+	LedgerPayout      = "payout"
+	LedgerBillResplit = "billResplit"
+)
+
+// How a refund reached the user, which decides who bears it in the ledger.
+const (
+	refundOnline    = "online"
+	refundCash      = "cash"
+	refundWriteDown = "writeDown"
+)
+
+var errUnbalancedLedger = errors.New("ledger transaction does not balance")
+
+type ledgerLine struct {
+	account string
+	amount  money.Money
+}
+
+// postLedger appends a transaction and its entries. The ledger is never
+// updated or deleted from. Each transaction has a key, so posting the same
+// event twice writes it once.
+func postLedger(tx *gorm.DB, transaction models.LedgerTransaction, lines []ledgerLine) error {
+	sum := money.Zero(transaction.Currency)
+	var entries []models.LedgerEntry
+	for _, line := range lines {
+		var err error
+		sum, err = sum.Add(line.amount)
+		if err != nil {
+			return err
+		}
+		if line.amount.IsZero() {
+			continue
+		}
+		entries = append(entries, models.LedgerEntry{
+			Account:      line.account,
+			SpecialistID: transaction.SpecialistID,
+			UserID:       transaction.UserID,
+			BillID:       transaction.BillID,
+			Amount:       line.amount,
+		})
+	}
+	if !sum.IsZero() {
+		return errUnbalancedLedger
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	transactionCreated := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&transaction)
+	if transactionCreated.Error != nil || transactionCreated.RowsAffected == 0 {
+		return transactionCreated.Error
+	}
+	for i := range entries {
+		entries[i].TransactionID = transaction.ID
+	}
+	return tx.Create(&entries).Error
+}
+
+func billTransaction(key string, event string, booking *models.Booking, bill *models.Bill) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Key:          key,
+		Event:        event,
+		Currency:     bill.Amount.Currency,
+		UserID:       booking.UserID,
+		SpecialistID: bill.SpecialistID,
+		BookingID:    &booking.ID,
+		BillID:       &bill.ID,
+	}
+}
+
+// postBillCreated records what the user owes and how it splits between the
+// specialist and the platform's commission.
+func postBillCreated(tx *gorm.DB, booking *models.Booking, bill *models.Bill) error {
+	earnings, err := bill.Amount.Sub(bill.PlatformFee)
+	if err != nil {
+		return err
+	}
+
+	key := "bill:" + strconv.FormatUint(uint64(bill.ID), 10) + ":created"
+	return postLedger(tx, billTransaction(key, LedgerBillCreated, booking, bill), []ledgerLine{
+		{AccountUserPayable, bill.Amount},
+		{AccountSpecialistReceivable, earnings.Neg()},
+		{AccountPlatformRevenue, bill.PlatformFee.Neg()},
+	})
+}
+
+// postBillPaid settles what the user owes. Online payments land with the
+// platform; cash goes straight to the specialist, who then owes the platform
+// its commission. Both share a key, so a bill is only ever paid once.
+func postBillPaid(tx *gorm.DB, booking *models.Booking, bill *models.Bill, online bool) error {
+	key := "bill:" + strconv.FormatUint(uint64(bill.ID), 10) + ":paid"
+	received := AccountSpecialistReceivable
+	if online {
+		received = AccountPlatformCash
+	}
+	return postLedger(tx, billTransaction(key, LedgerBillPaid, booking, bill), []ledgerLine{
+		{received, bill.Amount},
+		{AccountUserPayable, bill.Amount.Neg()},
+	})
+}
+
+// postBillResplit moves amount of a bill's earnings from its specialist to a
+// substitute who covered part of its period, or back when amount is
+// negative. Each side is its own transaction, balanced through the
+// substitutions account.
+func postBillResplit(tx *gorm.DB, booking *models.Booking, bill *models.Bill, substituteID uint, amount money.Money) error {
+	var resplits int64
+	resplitsCounted := tx.Model(&models.LedgerTransaction{}).
+		Where("bill_id = ? AND event = ? AND specialist_id = ?", bill.ID, LedgerBillResplit, substituteID).
+		Count(&resplits)
+	if resplitsCounted.Error != nil {
+		return resplitsCounted.Error
+	}
+	key := "bill:" + strconv.FormatUint(uint64(bill.ID), 10) + ":resplit:" +
+		strconv.FormatUint(uint64(substituteID), 10) + ":" + strconv.FormatInt(resplits, 10)
+
+	from := billTransaction(key+":from", LedgerBillResplit, booking, bill)
+	err := postLedger(tx, from, []ledgerLine{
+		{AccountSpecialistReceivable, amount},
+		{AccountSubstitutions, amount.Neg()},
+	})
+	if err != nil {
+		return err
+	}
+	to := billTransaction(key, LedgerBillResplit, booking, bill)
+	to.SpecialistID = substituteID
+	return postLedger(tx, to, []ledgerLine{
+		{AccountSubstitutions, amount},
+		{AccountSpecialistReceivable, amount.Neg()},
+	})
+}
+
+// billShares is what each substitute is owed out of a bill so far.
+func billShares(tx *gorm.DB, billID uint) (map[uint]money.Money, error) {
+	var rows []struct {
+		SpecialistID uint
+		Currency     string
+		Minor        int64
+	}
+	sharesExist := tx.Table("ledger_entries").
+		Select("ledger_entries.specialist_id, ledger_entries.amount_currency as currency, SUM(-ledger_entries.amount_minor) as minor").
+		Joins("INNER JOIN ledger_transactions on ledger_transactions.id = ledger_entries.transaction_id").
+		Where("ledger_entries.bill_id = ? AND ledger_entries.account = ? AND ledger_transactions.event = ?", billID, AccountSpecialistReceivable, LedgerBillResplit).
+		Where("ledger_transactions.key NOT LIKE ?", "%:from").
+		Where("ledger_entries.deleted_at IS NULL").
+		Group("ledger_entries.specialist_id, ledger_entries.amount_currency").
+		Scan(&rows)
+	if sharesExist.Error != nil {
+		return nil, sharesExist.Error
+	}
+
+	shares := make(map[uint]money.Money)
+	for _, row := range rows {
+		shares[row.SpecialistID] = money.Money{Minor: row.Minor, Currency: row.Currency}
+	}
+	return shares, nil
+}
+
+// postRefund splits a refund between the specialist and the platform in the
+// same proportion as the bill's commission.
+func postRefund(tx *gorm.DB, booking *models.Booking, bill *models.Bill, disputeID uint, amount money.Money, method string) error {
+	platformPart := money.Zero(amount.Currency)
+	if bill.Amount.IsPositive() {
+		platformPart = amount.Scale(float64(bill.PlatformFee.Minor) / float64(bill.Amount.Minor))
+	}
+	specialistPart, err := amount.Sub(platformPart)
+	if err != nil {
+		return err
+	}
+
+	var lines []ledgerLine
+	switch method {
+	case refundOnline:
+		lines = []ledgerLine{
+			{AccountPlatformCash, amount.Neg()},
+			{AccountSpecialistReceivable, specialistPart},
+			{AccountRefunds, platformPart},
+		}
+	case refundWriteDown:
+		lines = []ledgerLine{
+			{AccountUserPayable, amount.Neg()},
+			{AccountSpecialistReceivable, specialistPart},
+			{AccountRefunds, platformPart},
+		}
+	case refundCash:
+		// The specialist hands back the whole amount, so the platform owes
+		// them its share.
+		lines = []ledgerLine{
+			{AccountRefunds, platformPart},
+			{AccountSpecialistReceivable, platformPart.Neg()},
+		}
+	}
+
+	key := "dispute:" + strconv.FormatUint(uint64(disputeID), 10) + ":refund"
+	return postLedger(tx, billTransaction(key, LedgerRefund, booking, bill), lines)
+}
+
+// GetSpecialistEarnings reports a specialist's balances per currency. Pending
+// is owed on bills not paid yet, available is owed on paid bills less payouts,
+// and paid out is what the platform has already sent them.
+func GetSpecialistEarnings(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	balances, err := specialistBalances(claims.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	var entries []models.LedgerEntry
+	entriesExist := storage.DB.
+		Where("account = ? AND specialist_id = ?", AccountSpecialistReceivable, claims.ID).
+		Order("created_at DESC").
+		Limit(50).
+		Find(&entries)
+	if entriesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{"balances": balances, "entries": entries})
+}
+
+// RecordPayout books money the platform sent a specialist. The reference is
+// the transfer's own reference, so recording it twice does nothing.
+func RecordPayout(ctx iris.Context) {
+	var req PayoutInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	balances, err := specialistBalances(req.SpecialistID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	available := money.Zero(req.Amount.Currency)
+	for _, balance := range balances {
+		if balance.Available.Currency == req.Amount.Currency {
+			available = balance.Available
+		}
+	}
+	if !req.Amount.IsPositive() || req.Amount.Minor > available.Minor {
+		utils.CreateError(iris.StatusConflict, "Conflict", "The payout is more than the specialist's available balance.", ctx)
+		return
+	}
+
+	transaction := models.LedgerTransaction{
+		Key:          "payout:" + req.Reference,
+		Event:        LedgerPayout,
+		Currency:     req.Amount.Currency,
+		SpecialistID: req.SpecialistID,
+		Memo:         req.Reference,
+	}
+	payoutErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		return postLedger(tx, transaction, []ledgerLine{
+			{AccountSpecialistReceivable, req.Amount},
+			{AccountPlatformCash, req.Amount.Neg()},
+		})
+	})
+	if payoutErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifySpecialist(req.SpecialistID, "tabs/earningsScreen/", "Payout sent", req.Amount.String()+" is on its way to you.")
+	ctx.StatusCode(iris.StatusCreated)
+}
+
+// specialistBalances splits what a specialist is owed into what is still
+// pending and what is available to pay out. Earnings become available once
+// the bill's payment is in the ledger, which takes the payment provider or
+// both sides of a cash payment, not just the user marking it paid.
+func specialistBalances(specialistID uint) ([]EarningsBalance, error) {
+	var balances []EarningsBalance
+	balancesExist := storage.DB.Table("ledger_entries").
+		Select(`ledger_entries.amount_currency as pending_currency,
+		ledger_entries.amount_currency as available_currency,
+		ledger_entries.amount_currency as paid_out_currency,
+		COALESCE(SUM(-ledger_entries.amount_minor) FILTER (WHERE ledger_entries.bill_id IS NOT NULL AND bill_paid.id IS NULL), 0) as pending_minor,
+		COALESCE(SUM(-ledger_entries.amount_minor) FILTER (WHERE ledger_entries.bill_id IS NULL OR bill_paid.id IS NOT NULL), 0) as available_minor,
+		COALESCE(SUM(ledger_entries.amount_minor) FILTER (WHERE ledger_transactions.event = ?), 0) as paid_out_minor`, LedgerPayout).
+		Joins("INNER JOIN ledger_transactions on ledger_transactions.id = ledger_entries.transaction_id").
+		Joins("LEFT JOIN ledger_transactions bill_paid on bill_paid.bill_id = ledger_entries.bill_id AND bill_paid.event = ?", LedgerBillPaid).
+		Where("ledger_entries.account = ? AND ledger_entries.specialist_id = ?", AccountSpecialistReceivable, specialistID).
+		Where("ledger_entries.deleted_at IS NULL").
+		Group("ledger_entries.amount_currency").
+		Scan(&balances)
+	return balances, balancesExist.Error
+}
+
+type EarningsBalance struct {
+	Pending   money.Money `json:"pending" gorm:"embedded;embeddedPrefix:pending_"`
+	Available money.Money `json:"available" gorm:"embedded;embeddedPrefix:available_"`
+	PaidOut   money.Money `json:"paidOut" gorm:"embedded;embeddedPrefix:paid_out_"`
+}
+
+type PayoutInput struct {
+	SpecialistID uint        `json:"specialistID" validate:"required"`
+	Amount       money.Money `json:"amount"`
+	Reference    string      `json:"reference" validate:"required,max=128"`
+}
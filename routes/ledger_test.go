@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/money"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// ledgerRecorder opens a dry-run database that records what postLedger
+// creates instead of writing it. Transaction keys are unique, as they are
+// in the real table.
+func ledgerRecorder(t *testing.T) (*gorm.DB, *[]models.LedgerTransaction, *[]models.LedgerEntry) {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transactions []models.LedgerTransaction
+	var entries []models.LedgerEntry
+	keys := map[string]bool{}
+	err = db.Callback().Create().After("gorm:create").Register("test:record", func(db *gorm.DB) {
+		switch dest := db.Statement.Dest.(type) {
+		case *models.LedgerTransaction:
+			if keys[dest.Key] {
+				return
+			}
+			keys[dest.Key] = true
+			dest.ID = uint(len(transactions) + 1)
+			transactions = append(transactions, *dest)
+			db.RowsAffected = 1
+		case *[]models.LedgerEntry:
+			entries = append(entries, *dest...)
+			db.RowsAffected = int64(len(*dest))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, &transactions, &entries
+}
+
+func bdt(minor int64) money.Money {
+	return money.Money{Minor: minor, Currency: "BDT"}
+}
+
+func TestPostLedger(t *testing.T) {
+	db, transactions, entries := ledgerRecorder(t)
+	billID := uint(9)
+	transaction := models.LedgerTransaction{Key: "bill:9:created", Event: LedgerBillCreated, Currency: "BDT", UserID: 3, SpecialistID: 4, BillID: &billID}
+
+	err := postLedger(db, transaction, []ledgerLine{
+		{AccountUserPayable, bdt(100000)},
+		{AccountSpecialistReceivable, bdt(-90000)},
+		{AccountPlatformRevenue, bdt(-10000)},
+		{AccountRefunds, bdt(0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*transactions) != 1 {
+		t.Fatalf("wrote %d transactions; want 1", len(*transactions))
+	}
+	if len(*entries) != 3 {
+		t.Fatalf("wrote %d entries; want 3 with the zero line left out", len(*entries))
+	}
+	for _, entry := range *entries {
+		if entry.TransactionID != (*transactions)[0].ID || entry.UserID != 3 || entry.SpecialistID != 4 || entry.BillID == nil || *entry.BillID != billID {
+			t.Errorf("entry %+v does not carry the transaction's IDs", entry)
+		}
+	}
+	if (*entries)[1].Account != AccountSpecialistReceivable || (*entries)[1].Amount != bdt(-90000) {
+		t.Errorf("entry = %+v", (*entries)[1])
+	}
+
+	// Posting the same event again writes nothing.
+	err = postLedger(db, transaction, []ledgerLine{
+		{AccountUserPayable, bdt(100000)},
+		{AccountPlatformRevenue, bdt(-100000)},
+	})
+	if err != nil || len(*transactions) != 1 || len(*entries) != 3 {
+		t.Errorf("a repeated key wrote %d transactions and %d entries, %v", len(*transactions), len(*entries), err)
+	}
+}
+
+func TestPostLedgerUnbalanced(t *testing.T) {
+	db, transactions, entries := ledgerRecorder(t)
+
+	tests := []struct {
+		name  string
+		lines []ledgerLine
+		err   error
+	}{
+		{"unbalanced", []ledgerLine{{AccountUserPayable, bdt(100000)}, {AccountPlatformRevenue, bdt(-99999)}}, errUnbalancedLedger},
+		{"one-sided", []ledgerLine{{AccountRefunds, bdt(500)}}, errUnbalancedLedger},
+		{"mixed currencies", []ledgerLine{{AccountUserPayable, bdt(100)}, {AccountPlatformRevenue, money.Money{Minor: -100, Currency: "USD"}}}, money.ErrCurrencyMismatch},
+	}
+	for _, test := range tests {
+		transaction := models.LedgerTransaction{Key: test.name, Currency: "BDT"}
+		if err := postLedger(db, transaction, test.lines); !errors.Is(err, test.err) {
+			t.Errorf("%s: postLedger returned %v; want %v", test.name, err, test.err)
+		}
+	}
+
+	// Nothing to move is not an error, and nothing is written.
+	if err := postLedger(db, models.LedgerTransaction{Key: "empty", Currency: "BDT"}, []ledgerLine{{AccountRefunds, bdt(0)}}); err != nil {
+		t.Errorf("an all-zero transaction returned %v", err)
+	}
+	if len(*transactions) != 0 || len(*entries) != 0 {
+		t.Errorf("wrote %d transactions and %d entries; want none", len(*transactions), len(*entries))
+	}
+}
@@ -4,9 +4,16 @@ import (
 	"jotno-server/models"
 	"jotno-server/storage"
 	"jotno-server/utils"
+	"slices"
+	"time"
 
 	"github.com/kataras/iris/v12"
-	// "github.com/kataras/iris/v12/middleware/jwt"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+const (
+	defaultMessageLimit = 30
+	maxMessageLimit     = 100
 )
 
 func CreateMessage(ctx iris.Context) {
@@ -18,28 +25,213 @@ func CreateMessage(ctx iris.Context) {
 		return
 	}
 
-	// claims := jwt.Get(ctx).(*utils.AccessToken)
-
-	// if req.SenderID != claims.ID {
-	// 	ctx.StatusCode(iris.StatusForbidden)
-	// 	return
-	// }
+	chat := getChatForParticipant(req.ChatID, ctx)
+	if chat == nil {
+		return
+	}
 
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	receiverID := chat.SpecialistID
+	if claims.IsSpecialist() {
+		receiverID = chat.UserID
+	}
 	message := models.Message{
-		ChatID:     req.ChatID,
-		SenderID:   req.SenderID,
-		ReceiverID: req.ReceiverID,
+		ChatID:     chat.ID,
+		SenderID:   claims.ID,
+		SenderRole: recipientRole(claims),
+		ReceiverID: receiverID,
 		Text:       req.Text,
 	}
 
-	storage.DB.Create(&message)
+	messageCreated := storage.DB.Create(&message)
+	if messageCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
 
+	publishChatEvent(ChatMessageCreated, message)
 	ctx.JSON(message)
 }
 
+// EditMessage changes the text of one of the caller's messages.
+func EditMessage(ctx iris.Context) {
+	var req EditMessageInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	message := getOwnMessage(req.MessageID, ctx)
+	if message == nil {
+		return
+	}
+
+	now := time.Now()
+	messageUpdated := storage.DB.Model(message).Updates(map[string]interface{}{
+		"text":      req.Text,
+		"edited_at": now,
+	})
+	if messageUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	message.Text = req.Text
+	message.EditedAt = &now
+
+	publishChatEvent(ChatMessageEdited, *message)
+	ctx.JSON(message)
+}
+
+// DeleteMessage removes one of the caller's messages from the chat.
+func DeleteMessage(ctx iris.Context) {
+	messageID, parseErr := ctx.URLParamInt("messageId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid messageId.", ctx)
+		return
+	}
+
+	message := getOwnMessage(uint(messageID), ctx)
+	if message == nil {
+		return
+	}
+
+	messageDeleted := storage.DB.Delete(message)
+	if messageDeleted.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	publishChatEvent(ChatMessageDeleted, *message)
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// GetChatMessages pages through a chat's messages, newest first. Without a
+// cursor it returns the latest page; before pages back through older
+// messages and after fetches the ones sent since, both by message ID.
+func GetChatMessages(ctx iris.Context) {
+	var query MessagePageQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	chat := getChatForParticipant(query.ChatID, ctx)
+	if chat == nil {
+		return
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultMessageLimit
+	}
+	if limit > maxMessageLimit {
+		limit = maxMessageLimit
+	}
+
+	cursorID := query.Before
+	if query.After > 0 {
+		cursorID = query.After
+	}
+	messagesQuery := storage.DB.Where("chat_id = ?", chat.ID)
+	if cursorID > 0 {
+		// Deleted messages still mark their place in the chat.
+		var cursor models.Message
+		cursorExists := storage.DB.Unscoped().Where("id = ? AND chat_id = ?", cursorID, chat.ID).Find(&cursor)
+		if cursorExists.Error != nil {
+			utils.InternalServerError(ctx)
+			return
+		}
+		if cursorExists.RowsAffected == 0 {
+			utils.CreateError(iris.StatusBadRequest, "Bad Request", "The cursor is not a message of this chat.", ctx)
+			return
+		}
+		if query.After > 0 {
+			messagesQuery = messagesQuery.Where("(created_at, id) > (?, ?)", cursor.CreatedAt, cursor.ID)
+		} else {
+			messagesQuery = messagesQuery.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+	}
+
+	// Messages after the cursor are read oldest first so the page starts
+	// right after it, then turned around.
+	order := "created_at DESC, id DESC"
+	if query.After > 0 {
+		order = "created_at, id"
+	}
+	var messages []models.Message
+	messagesExist := messagesQuery.Order(order).Limit(limit).Find(&messages)
+	if messagesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if query.After > 0 {
+		slices.Reverse(messages)
+	}
+
+	page := MessagePage{Messages: messages, After: query.After}
+	if len(messages) > 0 {
+		page.After = messages[0].ID
+		if len(messages) == limit || query.After > 0 {
+			page.Before = messages[len(messages)-1].ID
+		}
+	}
+	ctx.JSON(page)
+}
+
+// getOwnMessage loads a message the caller sent in one of their chats. The
+// sender's role is compared too, as a user and a specialist may share an ID.
+func getOwnMessage(messageID uint, ctx iris.Context) *models.Message {
+	var message models.Message
+	messageExists := storage.DB.Where("id = ?", messageID).Find(&message)
+	if messageExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	if messageExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	var chat models.Chat
+	chatExists := storage.DB.Where("id = ?", message.ChatID).Find(&chat)
+	if chatExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if chatExists.RowsAffected == 0 || !chatParticipant(chat.UserID, chat.SpecialistID, claims) ||
+		message.SenderID != claims.ID || message.SenderRole != recipientRole(claims) {
+		utils.CreateForbidden(ctx)
+		return nil
+	}
+	return &message
+}
+
 type CreateMessageInput struct {
-	ChatID     uint   `json:"chatID" validate:"required"`
-	SenderID   uint   `json:"senderID" validate:"required"`
-	ReceiverID uint   `json:"receiverID" validate:"required"`
-	Text       string `json:"text" validate:"required,lt=5000"`
+	ChatID uint   `json:"chatID" validate:"required"`
+	Text   string `json:"text" validate:"required,lt=5000"`
+}
+
+type EditMessageInput struct {
+	MessageID uint   `json:"messageID" validate:"required"`
+	Text      string `json:"text" validate:"required,lt=5000"`
+}
+
+type MessagePageQuery struct {
+	ChatID uint `url:"chatId" validate:"required"`
+	Before uint `url:"before" validate:"excluded_with=After"`
+	After  uint `url:"after"`
+	Limit  int  `url:"limit" validate:"gte=0"`
+}
+
+// MessagePage holds the cursors for the pages around it: Before for older
+// messages, zero once the first message was reached, and After for newer
+// ones.
+type MessagePage struct {
+	Messages []models.Message `json:"messages"`
+	Before   uint             `json:"before"`
+	After    uint             `json:"after"`
 }
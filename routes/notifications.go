@@ -1,7 +1,15 @@
 package routes
 
 import (
+	"encoding/json"
+	"jotno-server/models"
+	"jotno-server/storage"
 	"jotno-server/utils"
+	"os"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/datatypes"
 )
 
 func SendNotification(
@@ -17,3 +25,100 @@ func SendNotification(
 		return
 	}
 }
+
+// appLink builds a deep link into the mobile app, e.g. appLink("tabs/bookingScreen/").
+func appLink(path string) string {
+	return os.Getenv("APP_LINK_URL") + path
+}
+
+func notifyUser(userID uint, path string, title string, body string) {
+	var user models.User
+	userExists := storage.DB.Where("id = ?", userID).Find(&user)
+	if userExists.Error != nil || userExists.RowsAffected == 0 {
+		return
+	}
+	notifyPushTokens(user.PushTokens, user.AllowsNotifications, path, title, body)
+}
+
+func notifySpecialist(specialistID uint, path string, title string, body string) {
+	var specialist models.Specialist
+	specialistExists := storage.DB.Where("id = ?", specialistID).Find(&specialist)
+	if specialistExists.Error != nil || specialistExists.RowsAffected == 0 {
+		return
+	}
+	notifyPushTokens(specialist.PushTokens, specialist.AllowsNotifications, path, title, body)
+}
+
+func notifyPushTokens(pushTokens datatypes.JSON, allowsNotifications *bool, path string, title string, body string) {
+	if pushTokens == nil || (allowsNotifications != nil && !*allowsNotifications) {
+		return
+	}
+
+	var tokens []string
+	unmarshalErr := json.Unmarshal(pushTokens, &tokens)
+	if unmarshalErr != nil {
+		return
+	}
+	for _, token := range tokens {
+		SendNotification(appLink(path), token, title, body)
+	}
+}
+
+func GetNotifications(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var notifications []models.Notification
+	notificationsExist := storage.DB.
+		Where("recipient_id = ? AND recipient_role = ?", claims.ID, recipientRole(claims)).
+		Order("created_at DESC").
+		Limit(100).
+		Find(&notifications)
+	if notificationsExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(notifications)
+}
+
+func MarkNotificationsRead(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req MarkNotificationsReadInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	notificationsRead := storage.DB.Model(&models.Notification{}).
+		Where("id IN ? AND recipient_id = ? AND recipient_role = ?", req.NotificationIDs, claims.ID, recipientRole(claims)).
+		Update("read", true)
+	if notificationsRead.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// createNotification stores a notification in the recipient's in-app inbox.
+func createNotification(recipientID uint, role string, path string, title string, body string) {
+	notification := models.Notification{
+		RecipientID:   recipientID,
+		RecipientRole: role,
+		Title:         title,
+		Body:          body,
+		Link:          appLink(path),
+	}
+	storage.DB.Create(&notification)
+}
+
+func recipientRole(claims *utils.AccessToken) string {
+	if claims.IsSpecialist() {
+		return utils.RoleSpecialist
+	}
+	return utils.RoleUser
+}
+
+type MarkNotificationsReadInput struct {
+	NotificationIDs []uint `json:"notificationIDs" validate:"required,min=1"`
+}
@@ -0,0 +1,325 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/recurrence"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+var errSpecialistUnavailable = errors.New("the specialist is already booked for some of these times")
+
+// Bookings the specialist has committed to, which new bookings must not clash
+// with.
+var committedBookingStatuses = []string{BookingAccepted, BookingActive, BookingPaused}
+
+// GetBookingOccurrences lists the visits a booking's schedule calls for
+// between from and to, marking the ones an accepted pause takes out.
+func GetBookingOccurrences(ctx iris.Context) {
+	var query OccurrencesQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+	maxDays := utils.EnvInt("OCCURRENCES_MAX_DAYS", 93)
+	if !query.To.After(query.From) || query.To.Sub(query.From) > time.Duration(maxDays)*24*time.Hour {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "to must be after from and at most "+strconv.Itoa(maxDays)+" days later.", ctx)
+		return
+	}
+
+	booking, _ := getBookingForViewer(query.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+
+	schedule, err := bookingOccurrenceSchedule(booking)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	changes, err := acceptedBookingChanges(booking.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	occurrences := []BookingOccurrence{}
+	for _, occurrence := range schedule.Between(query.From, query.To) {
+		occurrences = append(occurrences, BookingOccurrence{
+			Occurrence: occurrence,
+			Paused:     occurrencePaused(changes, occurrence),
+		})
+	}
+	ctx.JSON(iris.Map{
+		"bookingID":   booking.ID,
+		"recurrence":  booking.Recurrence,
+		"timezone":    booking.Timezone,
+		"occurrences": occurrences,
+	})
+}
+
+// GetSpecialistAvailability checks a proposed schedule against the bookings a
+// specialist has already committed to, the same check made when a booking is
+// requested, hired for or accepted. Only the clashing times of the proposed
+// schedule are returned, never anything about the other bookings.
+func GetSpecialistAvailability(ctx iris.Context) {
+	var query AvailabilityQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking := models.Booking{
+		SpecialistID: query.SpecialistID,
+		StartDate:    query.StartDate,
+		EndDate:      query.EndDate,
+	}
+	applyBookingSchedule(&booking, query.BookingScheduleInput)
+	schedule, err := bookingOccurrenceSchedule(&booking)
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", err.Error(), ctx)
+		return
+	}
+
+	conflicts, err := specialistConflicts(query.SpecialistID, schedule, time.Now(), 0)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(Availability{Available: len(conflicts) == 0, Clashes: requestedClashes(conflicts)})
+}
+
+// requestedClashes are the times of a proposed schedule that clash with the
+// specialist's other bookings, without saying what those bookings are.
+func requestedClashes(conflicts []AvailabilityConflict) []recurrence.Occurrence {
+	clashes := []recurrence.Occurrence{}
+	seen := make(map[int64]bool)
+	for _, conflict := range conflicts {
+		if seen[conflict.Requested.Start.Unix()] {
+			continue
+		}
+		seen[conflict.Requested.Start.Unix()] = true
+		clashes = append(clashes, conflict.Requested)
+	}
+	slices.SortFunc(clashes, func(a, b recurrence.Occurrence) int { return a.Start.Compare(b.Start) })
+	return clashes
+}
+
+// applyBookingSchedule copies a requested schedule onto a booking, filling in
+// a whole-day daily schedule in the default timezone for what was left out.
+func applyBookingSchedule(booking *models.Booking, input BookingScheduleInput) {
+	booking.Recurrence = input.Recurrence
+	if booking.Recurrence == "" {
+		booking.Recurrence = "FREQ=" + recurrence.Daily
+	}
+	booking.StartTime, booking.EndTime = input.StartTime, input.EndTime
+	if booking.StartTime == "" {
+		booking.StartTime = "00:00"
+	}
+	if booking.EndTime == "" {
+		booking.EndTime = booking.StartTime
+	}
+	booking.Timezone = input.Timezone
+	if booking.Timezone == "" {
+		booking.Timezone = recurrence.DefaultTimezone()
+	}
+}
+
+// bookingOccurrenceSchedule is the schedule of visits a booking calls for,
+// from its first day to its end date. Plain calendar dates are days in the
+// booking's timezone.
+func bookingOccurrenceSchedule(booking *models.Booking) (*recurrence.Schedule, error) {
+	location, err := time.LoadLocation(booking.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	firstDate := booking.StartDate
+	if start, err := time.Parse(time.RFC3339, booking.StartDate); err == nil {
+		firstDate = start.In(location).Format(time.DateOnly)
+	}
+
+	var end time.Time
+	if booking.EndDate != "" {
+		end, err = parseBookingEnd(booking.EndDate, location)
+		if err != nil {
+			return nil, errInvalidBookingDates
+		}
+	}
+
+	return recurrence.NewSchedule(booking.Recurrence, firstDate, booking.StartTime, booking.EndTime, booking.Timezone, end)
+}
+
+// specialistConflicts lists where a schedule clashes with the specialist's
+// committed bookings, and the visits they cover as a temporary substitute,
+// over AVAILABILITY_HORIZON_DAYS from from. It leaves out excludeBookingID
+// and visits taken out by an accepted pause.
+func specialistConflicts(specialistID uint, schedule *recurrence.Schedule, from time.Time, excludeBookingID uint) ([]AvailabilityConflict, error) {
+	if schedule.First.After(from) {
+		from = schedule.First
+	}
+	to := from.AddDate(0, 0, utils.EnvInt("AVAILABILITY_HORIZON_DAYS", 60))
+	proposed := schedule.Between(from, to)
+	conflicts := []AvailabilityConflict{}
+	if len(proposed) == 0 {
+		return conflicts, nil
+	}
+
+	var bookings []models.Booking
+	bookingsExist := storage.DB.
+		Where("specialist_id = ? AND status IN ? AND id <> ?", specialistID, committedBookingStatuses, excludeBookingID).
+		Find(&bookings)
+	if bookingsExist.Error != nil {
+		return nil, bookingsExist.Error
+	}
+
+	// Temporary covers only keep the specialist busy within their window.
+	windows := make(map[uint]*models.Substitution)
+	var covers []models.Substitution
+	coversExist := storage.DB.
+		Where("substitute_specialist_id = ? AND kind = ? AND status = ? AND booking_id <> ?", specialistID, SubstitutionTemporary, SubstitutionApproved, excludeBookingID).
+		Where("ends_at > ?", from).
+		Find(&covers)
+	if coversExist.Error != nil {
+		return nil, coversExist.Error
+	}
+	for i := range covers {
+		var booking models.Booking
+		bookingExists := storage.DB.Where("id = ? AND status IN ?", covers[i].BookingID, committedBookingStatuses).Find(&booking)
+		if bookingExists.Error != nil {
+			return nil, bookingExists.Error
+		}
+		if bookingExists.RowsAffected > 0 {
+			bookings = append(bookings, booking)
+			windows[booking.ID] = &covers[i]
+		}
+	}
+
+	for _, booking := range bookings {
+		bookingSchedule, err := bookingOccurrenceSchedule(&booking)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := acceptedBookingChanges(booking.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var busy []recurrence.Occurrence
+		for _, occurrence := range bookingSchedule.Between(from, to) {
+			cover := windows[booking.ID]
+			if cover != nil && (occurrence.Start.Before(cover.StartsAt) || !occurrence.Start.Before(*cover.EndsAt)) {
+				continue
+			}
+			if !occurrencePaused(changes, occurrence) {
+				busy = append(busy, occurrence)
+			}
+		}
+		for _, pair := range recurrence.Overlapping(proposed, busy) {
+			conflicts = append(conflicts, AvailabilityConflict{
+				BookingID: booking.ID,
+				Requested: pair[0],
+				Booked:    pair[1],
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// checkSpecialistAvailable answers with a conflict listing the clashes when
+// the booking's schedule does not fit the specialist's, and reports whether
+// the caller may go on.
+func checkSpecialistAvailable(booking *models.Booking, ctx iris.Context) bool {
+	schedule, err := bookingOccurrenceSchedule(booking)
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", err.Error(), ctx)
+		return false
+	}
+	conflicts, err := specialistConflicts(booking.SpecialistID, schedule, time.Now(), booking.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return false
+	}
+	if len(conflicts) > 0 {
+		ctx.StopWithProblem(
+			iris.StatusConflict,
+			iris.NewProblem().Title("Conflict").Detail(errSpecialistUnavailable.Error()).Key("clashes", requestedClashes(conflicts)))
+		return false
+	}
+	return true
+}
+
+// occurrencePaused reports whether accepted pauses cover the whole visit.
+func occurrencePaused(changes []models.BookingChangeRequest, occurrence recurrence.Occurrence) bool {
+	return pausedWithin(changes, occurrence.Start, occurrence.End) >= occurrence.End.Sub(occurrence.Start)
+}
+
+// validateBookingSchedule checks the optional schedule fields sent with a new
+// booking.
+func validateBookingSchedule(input BookingScheduleInput) error {
+	if input.Recurrence != "" {
+		if _, err := recurrence.Parse(input.Recurrence); err != nil {
+			return err
+		}
+	}
+	for _, clock := range []string{input.StartTime, input.EndTime} {
+		if clock == "" {
+			continue
+		}
+		if _, err := recurrence.ParseClock(clock); err != nil {
+			return err
+		}
+	}
+	if input.Timezone != "" {
+		if _, err := time.LoadLocation(input.Timezone); err != nil {
+			return errors.New("unknown timezone")
+		}
+	}
+	return nil
+}
+
+// BookingScheduleInput is the RRULE and daily window a booking repeats on.
+// Left out, a booking covers every whole day.
+type BookingScheduleInput struct {
+	Recurrence string `json:"recurrence" url:"recurrence" validate:"max=512"`
+	StartTime  string `json:"startTime" url:"startTime"`
+	EndTime    string `json:"endTime" url:"endTime"`
+	Timezone   string `json:"timezone" url:"timezone"`
+}
+
+type OccurrencesQuery struct {
+	BookingID uint      `url:"bookingId" validate:"required"`
+	From      time.Time `url:"from" validate:"required"`
+	To        time.Time `url:"to" validate:"required"`
+}
+
+type BookingOccurrence struct {
+	recurrence.Occurrence
+	Paused bool `json:"paused"`
+}
+
+type AvailabilityQuery struct {
+	BookingScheduleInput
+	SpecialistID uint   `url:"specialistId" validate:"required"`
+	StartDate    string `url:"startDate" validate:"required"`
+	EndDate      string `url:"endDate"`
+}
+
+type Availability struct {
+	Available bool                    `json:"available"`
+	Clashes   []recurrence.Occurrence `json:"clashes"`
+}
+
+type AvailabilityConflict struct {
+	BookingID uint                  `json:"bookingID"`
+	Requested recurrence.Occurrence `json:"requested"`
+	Booked    recurrence.Occurrence `json:"booked"`
+}
@@ -0,0 +1,362 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"jotno-server/models"
+	"jotno-server/payments"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StartCheckout opens a payment attempt for a bill and returns the provider
+// page the app should send the user to.
+func StartCheckout(ctx iris.Context) {
+	var req StartCheckoutInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	bill, booking, role := getBillForParty(req.BillID, ctx)
+	if bill == nil {
+		return
+	}
+	if role != utils.RoleUser {
+		utils.CreateForbidden(ctx)
+		return
+	}
+	if bill.Paid {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This bill has already been paid.", ctx)
+		return
+	}
+
+	provider, providerErr := payments.Default()
+	if req.Provider != "" {
+		provider, providerErr = payments.Get(req.Provider)
+	}
+	if providerErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "This payment provider is not available.", ctx)
+		return
+	}
+
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	reference, referenceErr := paymentReference(bill.ID)
+	if referenceErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	attempt := models.PaymentAttempt{
+		BillID:    bill.ID,
+		UserID:    booking.UserID,
+		Provider:  provider.Name(),
+		Method:    req.Method,
+		Reference: reference,
+		Amount:    bill.Amount,
+		Status:    payments.StatusPending,
+	}
+	attemptCreated := storage.DB.Create(&attempt)
+	if attemptCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	checkout, checkoutErr := provider.StartCheckout(payments.CheckoutRequest{
+		Reference:     reference,
+		Amount:        bill.Amount,
+		Method:        req.Method,
+		Description:   booking.JobType + " booking",
+		CustomerName:  user.FirstName + " " + user.LastName,
+		CustomerEmail: user.Email,
+		CustomerPhone: user.CallingCode + user.PhoneNumber,
+		CustomerCity:  user.City,
+		ReturnURL:     appLink("tabs/bookingScreen/?billId=" + strconv.FormatUint(uint64(bill.ID), 10)),
+		WebhookURL:    os.Getenv("API_BASE_URL") + "/jotno/api/payment/webhook/" + provider.Name(),
+	})
+	if checkoutErr != nil {
+		storage.DB.Model(&attempt).Updates(models.PaymentAttempt{Status: payments.StatusFailed, CheckoutPayload: checkoutErr.Error()})
+		utils.CreateError(iris.StatusBadGateway, "Payment Error", "Checkout could not be started. Please try again.", ctx)
+		return
+	}
+
+	attempt.RedirectURL = checkout.RedirectURL
+	attempt.ProviderReference = checkout.ProviderReference
+	attempt.CheckoutPayload = checkout.RawResponse
+	storage.DB.Model(&attempt).Updates(models.PaymentAttempt{
+		RedirectURL:       attempt.RedirectURL,
+		ProviderReference: attempt.ProviderReference,
+		CheckoutPayload:   attempt.CheckoutPayload,
+	})
+	ctx.JSON(attempt)
+}
+
+// PaymentWebhook records a provider's verdict on a payment attempt. Providers
+// retry deliveries, so an attempt that already succeeded is left as it is. A
+// payment for a bill settled meanwhile, such as in cash, is flagged for a
+// refund rather than charged twice.
+func PaymentWebhook(ctx iris.Context) {
+	provider, providerErr := payments.Get(ctx.Params().Get("provider"))
+	if providerErr != nil {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	event, err := provider.ParseWebhook(ctx.Request())
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "Invalid signature.", ctx)
+		return
+	}
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", err.Error(), ctx)
+		return
+	}
+
+	var attempt models.PaymentAttempt
+	var bill models.Bill
+	var booking models.Booking
+	settled := false
+	transactionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		attemptExists := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ? AND provider = ?", event.Reference, provider.Name()).
+			Find(&attempt)
+		if attemptExists.Error != nil {
+			return attemptExists.Error
+		}
+		if attemptExists.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if attempt.Status == payments.StatusSucceeded || attempt.Status == payments.StatusRefundDue || attempt.Status == payments.StatusRefunded {
+			return nil
+		}
+
+		attempt.WebhookPayload = event.RawPayload
+		attempt.Status = event.Status
+		if event.ProviderReference != "" {
+			attempt.ProviderReference = event.ProviderReference
+		}
+		if event.Status == payments.StatusSucceeded && event.Amount != attempt.Amount {
+			attempt.Status = payments.StatusFailed
+		}
+		if attempt.Status == payments.StatusSucceeded {
+			now := time.Now()
+			attempt.PaidAt = &now
+
+			// The gateway holds the money now, so the bill is settled without
+			// waiting for the specialist to confirm receipt. A cash payment the
+			// user only claimed does not settle it, so this one still counts.
+			billPaid := tx.Model(&models.Bill{}).
+				Where("id = ? AND complete = false", attempt.BillID).
+				Updates(map[string]interface{}{"paid": true, "paid_at": attempt.PaidAt, "complete": true})
+			if billPaid.Error != nil {
+				return billPaid.Error
+			}
+			settled = billPaid.RowsAffected > 0
+			if !settled {
+				attempt.Status = payments.StatusRefundDue
+			}
+		}
+
+		attemptUpdated := tx.Select("status", "provider_reference", "webhook_payload", "paid_at", "updated_at").Save(&attempt)
+		if attemptUpdated.Error != nil {
+			return attemptUpdated.Error
+		}
+		if !settled {
+			return nil
+		}
+
+		billExists := tx.Where("id = ?", attempt.BillID).Find(&bill)
+		if billExists.Error != nil {
+			return billExists.Error
+		}
+		bookingExists := tx.Where("id = ?", bill.BookingID).Find(&booking)
+		if bookingExists.Error != nil {
+			return bookingExists.Error
+		}
+		return postBillPaid(tx, &booking, &bill, true)
+	})
+	if errors.Is(transactionErr, gorm.ErrRecordNotFound) {
+		utils.CreateNotFound(ctx)
+		return
+	}
+	if transactionErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	if settled {
+		notifyBookingParty(&booking, ActorSystem, "Bill paid", "A payment of "+bill.Amount.String()+" went through.")
+	}
+	if attempt.Status == payments.StatusRefundDue {
+		// A failed refund stays due and is retried by RefundDuplicatePayments.
+		if err := refundDuplicatePayment(&attempt); err != nil {
+			log.Println("could not refund a duplicate payment:", attempt.Reference, err)
+		}
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// RefundDuplicatePayments hands back payments taken for bills that were
+// already settled, retrying any the webhook could not refund straight away.
+// It runs from the scheduler.
+func RefundDuplicatePayments(report *JobReport) error {
+	var attempts []models.PaymentAttempt
+	attemptsExist := storage.DB.Where("status = ?", payments.StatusRefundDue).Find(&attempts)
+	if attemptsExist.Error != nil {
+		return attemptsExist.Error
+	}
+
+	for i := range attempts {
+		err := refundDuplicatePayment(&attempts[i])
+		if err != nil {
+			report.Fail(fmt.Errorf("payment %s: %w", attempts[i].Reference, err))
+			continue
+		}
+		report.Processed()
+	}
+	return nil
+}
+
+// refundDuplicatePayment refunds the whole of a payment that was due a refund
+// and tells the user. The attempt only leaves refundDue once the provider has
+// accepted the refund, so a failure is tried again on the next run.
+func refundDuplicatePayment(attempt *models.PaymentAttempt) error {
+	provider, err := payments.Get(attempt.Provider)
+	if err != nil {
+		return err
+	}
+	_, err = provider.Refund(payments.RefundRequest{
+		Reference:         attempt.Reference,
+		ProviderReference: attempt.ProviderReference,
+		Amount:            attempt.Amount,
+		Reason:            "The bill was already paid.",
+	})
+	if err != nil {
+		return err
+	}
+
+	attemptRefunded := storage.DB.Model(attempt).
+		Where("status = ?", payments.StatusRefundDue).
+		Update("status", payments.StatusRefunded)
+	if attemptRefunded.Error != nil || attemptRefunded.RowsAffected == 0 {
+		return attemptRefunded.Error
+	}
+	notifyUser(
+		attempt.UserID,
+		"tabs/bookingScreen/?billId="+strconv.FormatUint(uint64(attempt.BillID), 10),
+		"Payment refunded",
+		"This bill was already paid, so your payment of "+attempt.Amount.String()+" is being refunded.",
+	)
+	return nil
+}
+
+// getBillForParty loads a bill the caller is a party to, along with its
+// booking and the caller's role in it. The specialist of a bill is the one
+// it pays, who is not the booking's specialist when a substitute covered
+// the period.
+func getBillForParty(billID uint, ctx iris.Context) (*models.Bill, *models.Booking, string) {
+	var bill models.Bill
+	billExists := storage.DB.Where("id = ?", billID).Find(&bill)
+	if billExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if billExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil, ""
+	}
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", bill.BookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil, ""
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if claims.IsSpecialist() && bill.SpecialistID == claims.ID {
+		return &bill, &booking, utils.RoleSpecialist
+	}
+	if !claims.IsSpecialist() && booking.UserID == claims.ID {
+		return &bill, &booking, utils.RoleUser
+	}
+	utils.CreateForbidden(ctx)
+	return nil, nil, ""
+}
+
+// getBillForViewer is getBillForParty that also lets in substitutes who were
+// given a share of the bill.
+func getBillForViewer(billID uint, ctx iris.Context) (*models.Bill, *models.Booking, string) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !claims.IsSpecialist() {
+		return getBillForParty(billID, ctx)
+	}
+
+	shares, err := billShares(storage.DB, billID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if _, ok := shares[claims.ID]; !ok {
+		return getBillForParty(billID, ctx)
+	}
+
+	var bill models.Bill
+	billExists := storage.DB.Where("id = ?", billID).Find(&bill)
+	if billExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if billExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil, ""
+	}
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", bill.BookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil, ""
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil, ""
+	}
+	return &bill, &booking, utils.RoleSpecialist
+}
+
+func paymentReference(billID uint) (string, error) {
+	suffix := make([]byte, 6)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return "bill" + strconv.FormatUint(uint64(billID), 10) + "-" + hex.EncodeToString(suffix), nil
+}
+
+type StartCheckoutInput struct {
+	BillID   uint   `json:"billID" validate:"required"`
+	Method   string `json:"method" validate:"required,oneof=card bkash nagad rocket"`
+	Provider string `json:"provider"`
+}
@@ -0,0 +1,228 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const (
+	AlertChannelPush  = "push"
+	AlertChannelInApp = "inApp"
+	AlertChannelEmail = "email"
+)
+
+func CreateSavedSearch(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req SavedSearchInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	jobTypes, marshalErr := json.Marshal(req.JobTypes)
+	if marshalErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	savedSearch := models.SavedSearch{
+		SpecialistID:  specialist.ID,
+		Name:          req.Name,
+		JobTypes:      jobTypes,
+		Lat:           req.Lat,
+		Lon:           req.Lon,
+		RadiusKm:      req.RadiusKm,
+		WageFrequency: req.WageFrequency,
+		Channel:       req.Channel,
+	}
+	if req.MinWage != nil {
+		savedSearch.MinWage = *req.MinWage
+	}
+	if savedSearch.Lat == 0 && savedSearch.Lon == 0 {
+		savedSearch.Lat = specialist.Lat
+		savedSearch.Lon = specialist.Lon
+	}
+
+	savedSearchCreated := storage.DB.Create(&savedSearch)
+	if savedSearchCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(savedSearch)
+}
+
+func GetSavedSearches(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var savedSearches []models.SavedSearch
+	savedSearchesExist := storage.DB.Where("specialist_id = ?", id).Order("created_at DESC").Find(&savedSearches)
+	if savedSearchesExist.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(savedSearches)
+}
+
+func DeleteSavedSearch(ctx iris.Context) {
+	id := ctx.URLParam("id")
+	savedSearchID := ctx.URLParam("savedSearchId")
+
+	savedSearchDeleted := storage.DB.Where("id = ? AND specialist_id = ?", savedSearchID, id).Delete(&models.SavedSearch{})
+	if savedSearchDeleted.Error != nil {
+		utils.CreateError(iris.StatusInternalServerError, "Error", savedSearchDeleted.Error.Error(), ctx)
+		return
+	}
+	if savedSearchDeleted.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// matchSavedSearches records an alert for every saved search the job post
+// satisfies and delivers push and in-app alerts straight away, push ones
+// within the daily cap of job post notifications. Email alerts wait for the
+// daily digest. It returns the specialists that were pushed or capped.
+func matchSavedSearches(jobPost *models.JobPost, poster *models.User) map[uint]bool {
+	pushed := make(map[uint]bool)
+
+	var savedSearches []models.SavedSearch
+	savedSearchesExist := storage.DB.
+		Where("job_types @> ?", `["`+jobPost.JobType+`"]`).
+		Where("(min_wage_minor = 0 OR (min_wage_currency = ? AND min_wage_minor <= ?))", jobPost.Wage.Currency, jobPost.Wage.Minor).
+		Where("(wage_frequency = '' OR wage_frequency = ?)", jobPost.WageFrequency).
+		Find(&savedSearches)
+	if savedSearchesExist.Error != nil {
+		return pushed
+	}
+
+	path := "screens/jobPost/JobPostScreen?jobPostId=" + strconv.FormatUint(uint64(jobPost.ID), 10)
+	for _, savedSearch := range savedSearches {
+		if savedSearch.RadiusKm > 0 {
+			distance := utils.DistanceKm(
+				float64(savedSearch.Lat), float64(savedSearch.Lon),
+				float64(poster.Lat), float64(poster.Lon),
+			)
+			if distance > savedSearch.RadiusKm {
+				continue
+			}
+		}
+
+		alert := models.JobAlert{
+			SavedSearchID: savedSearch.ID,
+			JobPostID:     jobPost.ID,
+			SpecialistID:  savedSearch.SpecialistID,
+			Channel:       savedSearch.Channel,
+		}
+		alertCreated := storage.DB.Where(models.JobAlert{SavedSearchID: savedSearch.ID, JobPostID: jobPost.ID}).FirstOrCreate(&alert)
+		if alertCreated.Error != nil || alertCreated.RowsAffected == 0 {
+			continue
+		}
+
+		alertTitle := "New job for " + savedSearch.Name
+		if savedSearch.Name == "" {
+			alertTitle = "New job for your saved search"
+		}
+
+		switch savedSearch.Channel {
+		case AlertChannelPush:
+			if pushed[savedSearch.SpecialistID] {
+				break
+			}
+			// Saved searches share the daily allowance of job post
+			// notifications, so the fan-out must not push them again.
+			pushed[savedSearch.SpecialistID] = true
+			if !withinJobPostDailyCap(savedSearch.SpecialistID) {
+				continue
+			}
+			notifySpecialist(savedSearch.SpecialistID, path, alertTitle, jobPost.Title)
+		case AlertChannelInApp:
+			createNotification(savedSearch.SpecialistID, utils.RoleSpecialist, path, alertTitle, jobPost.Title)
+		default:
+			continue
+		}
+
+		now := time.Now()
+		storage.DB.Model(&alert).Update("delivered_at", &now)
+	}
+	return pushed
+}
+
+// SendJobAlertDigests emails each specialist the job alerts collected for
+// their email saved searches since the last digest. It runs from the scheduler.
+func SendJobAlertDigests(report *JobReport) error {
+	var alerts []JobAlertDigestRow
+	alertsExist := storage.DB.Table("job_alerts").
+		Select(`job_alerts.id, job_alerts.specialist_id, specialists.email as specialist_email,
+		job_posts.title as job_post_title, job_posts.wage_minor as job_post_wage_minor,
+		job_posts.wage_currency as job_post_wage_currency, job_posts.wage_frequency as job_post_wage_frequency`).
+		Joins("INNER JOIN specialists on job_alerts.specialist_id = specialists.id").
+		Joins("INNER JOIN job_posts on job_alerts.job_post_id = job_posts.id").
+		Where("job_alerts.channel = ? AND job_alerts.delivered_at IS NULL AND job_alerts.deleted_at IS NULL", AlertChannelEmail).
+		Where("job_posts.status = ?", JobPostOpen).
+		Order("job_alerts.specialist_id, job_alerts.created_at").
+		Scan(&alerts)
+	if alertsExist.Error != nil {
+		return alertsExist.Error
+	}
+
+	digests := make(map[uint][]JobAlertDigestRow)
+	for _, alert := range alerts {
+		digests[alert.SpecialistID] = append(digests[alert.SpecialistID], alert)
+	}
+
+	for _, rows := range digests {
+		body := "<p>New jobs matching your saved searches:</p><ul>"
+		var alertIDs []uint
+		for _, row := range rows {
+			body += "<li>" + html.EscapeString(row.JobPostTitle) + " - " + html.EscapeString(row.JobPostWage.String()) + " " + html.EscapeString(row.JobPostWageFrequency) + "</li>"
+			alertIDs = append(alertIDs, row.ID)
+		}
+		body += "</ul>"
+
+		_, mailErr := utils.SendMail(rows[0].SpecialistEmail, "Your daily job alerts", body)
+		if mailErr != nil {
+			report.Fail(fmt.Errorf("specialist %d: %w", rows[0].SpecialistID, mailErr))
+			continue
+		}
+		storage.DB.Model(&models.JobAlert{}).Where("id IN ?", alertIDs).Update("delivered_at", time.Now())
+		report.Processed()
+	}
+	return nil
+}
+
+type JobAlertDigestRow struct {
+	ID                   uint
+	SpecialistID         uint
+	SpecialistEmail      string
+	JobPostTitle         string
+	JobPostWage          money.Money `gorm:"embedded;embeddedPrefix:job_post_wage_"`
+	JobPostWageFrequency string
+}
+
+type SavedSearchInput struct {
+	Name          string       `json:"name" validate:"max=64"`
+	JobTypes      []string     `json:"jobTypes" validate:"required,min=1,dive,oneof=petCare elderlyCare babySitting houseKeeping teaching"`
+	Lat           float32      `json:"lat"`
+	Lon           float32      `json:"lon"`
+	RadiusKm      float64      `json:"radiusKm" validate:"gte=0"`
+	MinWage       *money.Money `json:"minWage"`
+	WageFrequency string       `json:"wageFrequency" validate:"omitempty,oneof=monthly daily"`
+	Channel       string       `json:"channel" validate:"required,oneof=push inApp email"`
+}
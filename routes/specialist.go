@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm/clause"
 )
 
@@ -45,7 +46,73 @@ func RegisterSpecialist(ctx iris.Context) {
 		Lon:         specialistInput.Lon,
 	}
 	storage.DB.Create(&newSpecialist)
-	returnSpecialist(newSpecialist, ctx)
+	returnSpecialistWithTokens(newSpecialist, ctx)
+}
+
+func LoginSpecialist(ctx iris.Context) {
+	errorMsg := "Invalid email or password."
+	var specialistInput UserLoginInput
+	err := ctx.ReadJSON(&specialistInput)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	var specialist models.Specialist
+	specialistExists, specialistExistsError := specialistExistsInDB(&specialist, specialistInput.Email)
+
+	if specialistExistsError != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if !specialistExists {
+		utils.CreateError(iris.StatusUnauthorized,
+			"Authentication Failure",
+			errorMsg,
+			ctx,
+		)
+		return
+	}
+	passwordError := bcrypt.CompareHashAndPassword([]byte(specialist.Password), []byte(specialistInput.Password))
+	if passwordError != nil {
+		utils.CreateError(iris.StatusUnauthorized,
+			"Authentication Failure",
+			errorMsg,
+			ctx,
+		)
+		return
+	}
+	returnSpecialistWithTokens(specialist, ctx)
+}
+
+func AlterSpecialistPushToken(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req AlterPushTokenInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	pushTokens, alterErr := alterPushTokens(specialist.PushTokens, req)
+	if alterErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	specialist.PushTokens = pushTokens
+	rowsUpdated := storage.DB.Model(&specialist).Updates(specialist)
+	if rowsUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
 }
 
 func GetSpecialistByID(ctx iris.Context) {
@@ -111,6 +178,47 @@ func specialistExistsInDB(user *models.Specialist, email string) (exist bool, er
 	return false, nil
 }
 
+func AllowsSpecialistNotifications(ctx iris.Context) {
+	id := ctx.URLParam("id")
+
+	var req AllowsNotificationsInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	specialist := getSpecialistByID(id, ctx)
+	if specialist == nil {
+		return
+	}
+
+	specialist.AllowsNotifications = req.AllowsNotifications
+
+	rowsUpdated := storage.DB.Model(&specialist).Updates(specialist)
+
+	if rowsUpdated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func getSpecialistByID(id string, ctx iris.Context) *models.Specialist {
+	var specialist models.Specialist
+	specialistExists := storage.DB.Where("id = ?", id).Find(&specialist)
+
+	if specialistExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil
+	}
+	if specialistExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+	return &specialist
+}
+
 func getSpecialistAndAssociationsByID(id string, ctx iris.Context) *models.Specialist {
 
 	var specialist models.Specialist
@@ -152,6 +260,20 @@ func returnSpecialist(user models.Specialist, ctx iris.Context) {
 	ctx.JSON(specialistMap(user))
 }
 
+func returnSpecialistWithTokens(user models.Specialist, ctx iris.Context) {
+	tokenPair, tokenErr := utils.CreateTokenPair(user.ID, utils.RoleSpecialist)
+	if tokenErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	specialist := specialistMap(user)
+	specialist["allowsNotifications"] = user.AllowsNotifications
+	specialist["accessToken"] = string(tokenPair.AccessToken)
+	specialist["refreshToken"] = string(tokenPair.RefreshToken)
+	ctx.JSON(specialist)
+}
+
 func specialistMap(user models.Specialist) iris.Map {
 	return iris.Map{
 		"ID":          user.ID,
@@ -0,0 +1,554 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	SubstitutionTemporary = "temporary"
+	SubstitutionPermanent = "permanent"
+)
+
+const (
+	SubstitutionOpen     = "open"
+	SubstitutionOffered  = "offered"
+	SubstitutionApproved = "approved"
+)
+
+var (
+	errSubstitutionOpen     = errors.New("the booking is already waiting for a substitute")
+	errSubstitutionAnswered = errors.New("the substitution was already answered")
+)
+
+// Bookings a specialist can drop out of and be substituted on.
+var substitutableBookingStatuses = []string{BookingAccepted, BookingActive, BookingPaused}
+
+// DropOutOfBooking lets the booked specialist step away from a booking, for a
+// while when until is given and for good otherwise. Nobody is billed for the
+// visits they leave until the user approves a substitute.
+func DropOutOfBooking(ctx iris.Context) {
+	var req DropOutInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, role := getBookingForParty(req.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+	if role != utils.RoleSpecialist {
+		utils.CreateForbidden(ctx)
+		return
+	}
+	if !slices.Contains(substitutableBookingStatuses, booking.Status) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You can only drop out of a booking that is under way.", ctx)
+		return
+	}
+
+	now := time.Now()
+	from := now
+	if req.From != nil && req.From.After(now) {
+		from = *req.From
+	}
+	if req.Until != nil && !req.Until.After(from) {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "until must be after from.", ctx)
+		return
+	}
+
+	substitution, err := dropOut(booking, from, req.Until, req.Reason)
+	if errors.Is(err, errSubstitutionOpen) {
+		utils.CreateError(iris.StatusConflict, "Conflict", err.Error(), ctx)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(substitution)
+}
+
+// dropOut opens a substitution for the booked specialist from from on and
+// asks the user to pick a substitute. Dropping out counts towards being
+// flagged for cancelling often.
+func dropOut(booking *models.Booking, from time.Time, until *time.Time, reason string) (*models.Substitution, error) {
+	substitution := models.Substitution{
+		BookingID:            booking.ID,
+		OriginalSpecialistID: booking.SpecialistID,
+		Kind:                 SubstitutionPermanent,
+		Status:               SubstitutionOpen,
+		Reason:               reason,
+		StartsAt:             from,
+		EndsAt:               until,
+	}
+	if until != nil {
+		substitution.Kind = SubstitutionTemporary
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		var open int64
+		openQuery := tx.Model(&models.Substitution{}).
+			Where("booking_id = ? AND status IN ?", booking.ID, []string{SubstitutionOpen, SubstitutionOffered}).
+			Count(&open)
+		if openQuery.Error != nil {
+			return openQuery.Error
+		}
+		if open > 0 {
+			return errSubstitutionOpen
+		}
+
+		substitutionCreated := tx.Create(&substitution)
+		if substitutionCreated.Error != nil {
+			return substitutionCreated.Error
+		}
+		historyCreated := tx.Create(&models.BookingHistory{
+			BookingID:  booking.ID,
+			FromStatus: booking.Status,
+			ToStatus:   booking.Status,
+			ActorID:    booking.SpecialistID,
+			ActorRole:  utils.RoleSpecialist,
+			Reason:     "Specialist dropped out: " + reason,
+		})
+		if historyCreated.Error != nil {
+			return historyCreated.Error
+		}
+		return flagRepeatCanceller(tx, booking.SpecialistID, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := "Your specialist can no longer come from " + from.Format(time.DateOnly)
+	if until != nil {
+		body += " until " + until.Format(time.DateOnly)
+	}
+	notifyUser(booking.UserID, substitutionPath(&substitution), "Choose a substitute", body+". Pick a substitute to keep your visits going.")
+	return &substitution, nil
+}
+
+// GetSubstitutions lists the substitutions of a booking for either party.
+func GetSubstitutions(ctx iris.Context) {
+	bookingID, parseErr := ctx.URLParamInt("bookingId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid bookingId.", ctx)
+		return
+	}
+
+	booking, _ := getBookingForViewer(uint(bookingID), ctx)
+	if booking == nil {
+		return
+	}
+
+	substitutions, err := bookingSubstitutions(booking.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(substitutions)
+}
+
+// GetSubstituteCandidates proposes specialists who offer the booking's job
+// within SUBSTITUTE_RADIUS_KM of the user and are free for every visit the
+// substitution covers, nearest first.
+func GetSubstituteCandidates(ctx iris.Context) {
+	substitutionID, parseErr := ctx.URLParamInt("substitutionId")
+	if parseErr != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid substitutionId.", ctx)
+		return
+	}
+
+	substitution, booking := getSubstitutionForUser(uint(substitutionID), ctx)
+	if substitution == nil {
+		return
+	}
+
+	candidates, err := substituteCandidates(booking, substitution)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(candidates)
+}
+
+// ApproveSubstitute offers the substitution to one of the proposed
+// specialists. A temporary substitute covers the visits the original
+// specialist dropped out of; a permanent one takes the booking over from then
+// on. Nothing changes until the substitute accepts.
+func ApproveSubstitute(ctx iris.Context) {
+	var req ApproveSubstituteInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	substitution, booking := getSubstitutionForUser(req.SubstitutionID, ctx)
+	if substitution == nil {
+		return
+	}
+	if substitution.Status != SubstitutionOpen {
+		utils.CreateError(iris.StatusConflict, "Conflict", errSubstitutionAnswered.Error(), ctx)
+		return
+	}
+	if !req.Permanent && substitution.EndsAt == nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "The specialist dropped out for good, so the substitute must be permanent.", ctx)
+		return
+	}
+	substitution.OfferedPermanent = req.Permanent
+
+	candidates, err := substituteCandidates(booking, offeredSubstitution(substitution))
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if !slices.ContainsFunc(candidates, func(candidate SubstituteCandidate) bool { return candidate.ID == req.SpecialistID }) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This specialist is not available as a substitute.", ctx)
+		return
+	}
+
+	substitutionOffered := storage.DB.Model(substitution).Where("status = ?", SubstitutionOpen).Updates(map[string]interface{}{
+		"status":                   SubstitutionOffered,
+		"substitute_specialist_id": req.SpecialistID,
+		"offered_permanent":        req.Permanent,
+	})
+	if substitutionOffered.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if substitutionOffered.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", errSubstitutionAnswered.Error(), ctx)
+		return
+	}
+
+	substitution.Status = SubstitutionOffered
+	substitution.SubstituteSpecialistID = &req.SpecialistID
+	notifySpecialist(req.SpecialistID, substitutionPath(substitution), "Substitute request", "You were asked to substitute on a booking from "+substitution.StartsAt.Format(time.DateOnly)+". Accept or decline the request.")
+	ctx.JSON(substitution)
+}
+
+// AcceptSubstitution lets the offered substitute take the visits on, as long
+// as they are still free for all of them.
+func AcceptSubstitution(ctx iris.Context) {
+	var req AnswerSubstitutionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	substitution, booking := getSubstitutionForSubstitute(req.SubstitutionID, ctx)
+	if substitution == nil {
+		return
+	}
+	substitution = offeredSubstitution(substitution)
+	substituteID := *substitution.SubstituteSpecialistID
+
+	schedule, err := bookingOccurrenceSchedule(booking)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if substitution.EndsAt != nil && (schedule.End == nil || substitution.EndsAt.Before(*schedule.End)) {
+		schedule.End = substitution.EndsAt
+	}
+	conflicts, err := specialistConflicts(substituteID, schedule, substitution.StartsAt, booking.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if len(conflicts) > 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You are no longer free for every visit of this substitution.", ctx)
+		return
+	}
+
+	now := time.Now()
+	transactionErr := storage.DB.Transaction(func(tx *gorm.DB) error {
+		substitutionApproved := tx.Model(substitution).Where("status = ?", SubstitutionOffered).Updates(map[string]interface{}{
+			"status":      SubstitutionApproved,
+			"kind":        substitution.Kind,
+			"ends_at":     substitution.EndsAt,
+			"approved_at": now,
+		})
+		if substitutionApproved.Error != nil {
+			return substitutionApproved.Error
+		}
+		if substitutionApproved.RowsAffected == 0 {
+			return errSubstitutionAnswered
+		}
+
+		if substitution.Kind == SubstitutionPermanent {
+			bookingUpdated := tx.Model(booking).Update("specialist_id", substituteID)
+			if bookingUpdated.Error != nil {
+				return bookingUpdated.Error
+			}
+		}
+		return tx.Create(&models.BookingHistory{
+			BookingID:  booking.ID,
+			FromStatus: booking.Status,
+			ToStatus:   booking.Status,
+			ActorID:    substituteID,
+			ActorRole:  utils.RoleSpecialist,
+			Reason:     "Accepted a " + substitution.Kind + " substitution",
+		}).Error
+	})
+	if errors.Is(transactionErr, errSubstitutionAnswered) {
+		utils.CreateError(iris.StatusConflict, "Conflict", transactionErr.Error(), ctx)
+		return
+	}
+	if transactionErr != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	substitution.Status = SubstitutionApproved
+	substitution.ApprovedAt = &now
+	notifyUser(booking.UserID, bookingPath(booking.ID), "Substitute confirmed", "Your "+substitution.Kind+" substitute accepted and will visit from "+substitution.StartsAt.Format(time.DateOnly)+".")
+	notifySpecialist(substitution.OriginalSpecialistID, bookingPath(booking.ID), "Substitute found", "A "+substitution.Kind+" substitute will take over your visits.")
+	ctx.JSON(substitution)
+}
+
+// DeclineSubstitution turns the offer down, so the user can pick someone
+// else.
+func DeclineSubstitution(ctx iris.Context) {
+	var req AnswerSubstitutionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	substitution, booking := getSubstitutionForSubstitute(req.SubstitutionID, ctx)
+	if substitution == nil {
+		return
+	}
+
+	substitutionDeclined := storage.DB.Model(substitution).Where("status = ?", SubstitutionOffered).Updates(map[string]interface{}{
+		"status":                   SubstitutionOpen,
+		"substitute_specialist_id": nil,
+		"offered_permanent":        false,
+	})
+	if substitutionDeclined.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if substitutionDeclined.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", errSubstitutionAnswered.Error(), ctx)
+		return
+	}
+
+	substitution.Status = SubstitutionOpen
+	substitution.SubstituteSpecialistID = nil
+	substitution.OfferedPermanent = false
+	notifyUser(booking.UserID, substitutionPath(substitution), "Choose another substitute", "The specialist you picked can't substitute. Pick another one to keep your visits going.")
+	ctx.JSON(substitution)
+}
+
+// offeredSubstitution is the substitution as it will be once accepted: a
+// permanent offer takes the booking over for good.
+func offeredSubstitution(substitution *models.Substitution) *models.Substitution {
+	offered := *substitution
+	if offered.OfferedPermanent {
+		offered.Kind = SubstitutionPermanent
+		offered.EndsAt = nil
+	}
+	return &offered
+}
+
+// substituteCandidates finds the specialists who could take on a
+// substitution, at most SUBSTITUTE_CANDIDATES of them.
+func substituteCandidates(booking *models.Booking, substitution *models.Substitution) ([]SubstituteCandidate, error) {
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil {
+		return nil, userExists.Error
+	}
+
+	radiusKm := utils.EnvFloat("SUBSTITUTE_RADIUS_KM", 10)
+	lat := float64(user.Lat)
+	lon := float64(user.Lon)
+
+	var specialists []models.Specialist
+	subQuery := storage.DB.Select("specialist_id").Where("job_name = ?", booking.JobType).Table("jobs")
+	specialistsExist := storage.DB.
+		Where("id IN (?) AND id NOT IN ?", subQuery, []uint{substitution.OriginalSpecialistID, booking.SpecialistID}).
+		Where(utils.DistanceSQL("lat", "lon")+" <= ?", lat, lon, lat, radiusKm).
+		Find(&specialists)
+	if specialistsExist.Error != nil {
+		return nil, specialistsExist.Error
+	}
+
+	candidates := []SubstituteCandidate{}
+	for _, specialist := range specialists {
+		candidates = append(candidates, SubstituteCandidate{
+			ID:         specialist.ID,
+			FirstName:  specialist.FirstName,
+			LastName:   specialist.LastName,
+			Avatar:     specialist.Avatar,
+			Stars:      specialist.Stars,
+			Verified:   specialist.Verified,
+			DistanceKm: utils.DistanceKm(lat, lon, float64(specialist.Lat), float64(specialist.Lon)),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].DistanceKm < candidates[j].DistanceKm })
+
+	schedule, err := bookingOccurrenceSchedule(booking)
+	if err != nil {
+		return nil, err
+	}
+	if substitution.EndsAt != nil && (schedule.End == nil || substitution.EndsAt.Before(*schedule.End)) {
+		schedule.End = substitution.EndsAt
+	}
+
+	available := []SubstituteCandidate{}
+	limit := utils.EnvInt("SUBSTITUTE_CANDIDATES", 10)
+	for _, candidate := range candidates {
+		if len(available) >= limit {
+			break
+		}
+		conflicts, err := specialistConflicts(candidate.ID, schedule, substitution.StartsAt, booking.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) == 0 {
+			available = append(available, candidate)
+		}
+	}
+	return available, nil
+}
+
+// bookingSubstitutions lists a booking's substitutions in the order they
+// start.
+func bookingSubstitutions(bookingID uint) ([]models.Substitution, error) {
+	var substitutions []models.Substitution
+	substitutionsExist := storage.DB.Where("booking_id = ?", bookingID).Order("starts_at, id").Find(&substitutions)
+	return substitutions, substitutionsExist.Error
+}
+
+// specialistAt is who covers the booking at t, or zero when the specialist
+// has dropped out and no substitute was approved. Permanent substitutes took
+// the booking over, so before they started it was their predecessor's.
+func specialistAt(booking *models.Booking, substitutions []models.Substitution, t time.Time) uint {
+	specialistID := booking.SpecialistID
+	for i := len(substitutions) - 1; i >= 0; i-- {
+		substitution := substitutions[i]
+		if substitution.Kind == SubstitutionPermanent && substitution.Status == SubstitutionApproved && t.Before(substitution.StartsAt) {
+			specialistID = substitution.OriginalSpecialistID
+		}
+	}
+
+	for i := len(substitutions) - 1; i >= 0; i-- {
+		substitution := substitutions[i]
+		if t.Before(substitution.StartsAt) || (substitution.EndsAt != nil && !t.Before(*substitution.EndsAt)) {
+			continue
+		}
+		if substitution.Status != SubstitutionApproved {
+			return 0
+		}
+		return *substitution.SubstituteSpecialistID
+	}
+	return specialistID
+}
+
+// getSubstitutionForSubstitute loads a substitution offered to the caller,
+// for them to answer.
+func getSubstitutionForSubstitute(substitutionID uint, ctx iris.Context) (*models.Substitution, *models.Booking) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	var substitution models.Substitution
+	substitutionExists := storage.DB.Where("id = ?", substitutionID).Find(&substitution)
+	if substitutionExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if substitutionExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil
+	}
+	if substitution.SubstituteSpecialistID == nil || *substitution.SubstituteSpecialistID != claims.ID {
+		utils.CreateForbidden(ctx)
+		return nil, nil
+	}
+	if substitution.Status != SubstitutionOffered {
+		utils.CreateError(iris.StatusConflict, "Conflict", errSubstitutionAnswered.Error(), ctx)
+		return nil, nil
+	}
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", substitution.BookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil
+	}
+	return &substitution, &booking
+}
+
+// getSubstitutionForUser loads a substitution on one of the caller's
+// bookings, for the user to decide on.
+func getSubstitutionForUser(substitutionID uint, ctx iris.Context) (*models.Substitution, *models.Booking) {
+	var substitution models.Substitution
+	substitutionExists := storage.DB.Where("id = ?", substitutionID).Find(&substitution)
+	if substitutionExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if substitutionExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil
+	}
+
+	booking, role := getBookingForParty(substitution.BookingID, ctx)
+	if booking == nil {
+		return nil, nil
+	}
+	if role != utils.RoleUser {
+		utils.CreateForbidden(ctx)
+		return nil, nil
+	}
+	return &substitution, booking
+}
+
+func substitutionPath(substitution *models.Substitution) string {
+	return bookingPath(substitution.BookingID) + "&substitutionId=" + strconv.FormatUint(uint64(substitution.ID), 10)
+}
+
+type DropOutInput struct {
+	BookingID uint       `json:"bookingID" validate:"required"`
+	From      *time.Time `json:"from"`
+	Until     *time.Time `json:"until"`
+	Reason    string     `json:"reason" validate:"required,max=512"`
+}
+
+type ApproveSubstituteInput struct {
+	SubstitutionID uint `json:"substitutionID" validate:"required"`
+	SpecialistID   uint `json:"specialistID" validate:"required"`
+	Permanent      bool `json:"permanent"`
+}
+
+type AnswerSubstitutionInput struct {
+	SubstitutionID uint `json:"substitutionID" validate:"required"`
+}
+
+type SubstituteCandidate struct {
+	ID         uint    `json:"ID"`
+	FirstName  string  `json:"firstName"`
+	LastName   string  `json:"lastName"`
+	Avatar     string  `json:"avatar"`
+	Stars      int     `json:"stars"`
+	Verified   bool    `json:"verified"`
+	DistanceKm float64 `json:"distanceKm"`
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/kataras/iris/v12"
 	jsonWT "github.com/kataras/iris/v12/middleware/jwt"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/datatypes"
 )
 
 const baseImage = "https://encrypted-tbn0.gstatic.com/images?q=tbn:ANd9GcT8whvraQ8GE5WRpAHd-7-v2m-rccRLF8BMPNG92HhmHB1T0yxxa4fPEPDvfXtYfew7FBE&usqp=CAU"
@@ -472,39 +473,13 @@ func AlterPushToken(ctx iris.Context) {
 		return
 	}
 
-	var unMarshalledTokens []string
-	var pushTokens []string
-
-	if user.PushTokens != nil {
-		unmarshalErr := json.Unmarshal(user.PushTokens, &unMarshalledTokens)
-
-		if unmarshalErr != nil {
-			utils.InternalServerError(ctx)
-			return
-		}
-	}
-
-	if req.Op == "add" {
-		if !slices.Contains(unMarshalledTokens, req.Token) {
-			pushTokens = append(unMarshalledTokens, req.Token)
-		} else {
-			pushTokens = unMarshalledTokens
-		}
-	} else if req.Op == "remove" && len(unMarshalledTokens) > 0 {
-		for _, token := range unMarshalledTokens {
-			if req.Token != token {
-				pushTokens = append(pushTokens, token)
-			}
-		}
-	}
-
-	marshalledTokens, marshalErr := json.Marshal(pushTokens)
-	if marshalErr != nil {
+	pushTokens, alterErr := alterPushTokens(user.PushTokens, req)
+	if alterErr != nil {
 		utils.InternalServerError(ctx)
 		return
 	}
 
-	user.PushTokens = marshalledTokens
+	user.PushTokens = pushTokens
 	rowsUpdated := storage.DB.Model(&user).Updates(user)
 	if rowsUpdated.Error != nil {
 		utils.InternalServerError(ctx)
@@ -539,6 +514,35 @@ func AllowsNotifications(ctx iris.Context) {
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+func alterPushTokens(currentTokens datatypes.JSON, req AlterPushTokenInput) (datatypes.JSON, error) {
+	var unMarshalledTokens []string
+	var pushTokens []string
+
+	if currentTokens != nil {
+		unmarshalErr := json.Unmarshal(currentTokens, &unMarshalledTokens)
+
+		if unmarshalErr != nil {
+			return nil, unmarshalErr
+		}
+	}
+
+	if req.Op == "add" {
+		if !slices.Contains(unMarshalledTokens, req.Token) {
+			pushTokens = append(unMarshalledTokens, req.Token)
+		} else {
+			pushTokens = unMarshalledTokens
+		}
+	} else if req.Op == "remove" && len(unMarshalledTokens) > 0 {
+		for _, token := range unMarshalledTokens {
+			if req.Token != token {
+				pushTokens = append(pushTokens, token)
+			}
+		}
+	}
+
+	return json.Marshal(pushTokens)
+}
+
 func getUserByID(id string, ctx iris.Context) *models.User {
 	var user models.User
 	userExists := storage.DB.Where("id = ?", id).Find(&user)
@@ -577,7 +581,7 @@ func userExistsInDB(user *models.User, email string) (exist bool, err error) {
 }
 
 func returnUser(user models.User, ctx iris.Context) {
-	tokenPair, tokenErr := utils.CreateTokenPair(user.ID)
+	tokenPair, tokenErr := utils.CreateTokenPair(user.ID, utils.RoleUser)
 	if tokenErr != nil {
 		utils.InternalServerError(ctx)
 		return
@@ -0,0 +1,319 @@
+package routes
+
+import (
+	"errors"
+	"jotno-server/models"
+	"jotno-server/storage"
+	"jotno-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+var errOutsideVisitTolerance = errors.New("outside the visit tolerance")
+
+// CheckIn starts a visit for the booked specialist. The device location must
+// be within VISIT_TOLERANCE_METERS of the user's stored location; a user
+// without one is visited unverified.
+func CheckIn(ctx iris.Context) {
+	var req VisitInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	booking, user := getBookingForVisit(req.BookingID, ctx)
+	if booking == nil {
+		return
+	}
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	distance, verified, err := visitDistance(user, req)
+	if errors.Is(err, errOutsideVisitTolerance) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Too Far", "You need to be at the booking's address to check in.", ctx)
+		return
+	}
+
+	var openVisits int64
+	openQuery := storage.DB.Model(&models.Visit{}).Where("booking_id = ? AND check_out_at IS NULL", booking.ID).Count(&openVisits)
+	if openQuery.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if openVisits > 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You are already checked in to this booking.", ctx)
+		return
+	}
+
+	visit := models.Visit{
+		BookingID:        booking.ID,
+		SpecialistID:     claims.ID,
+		CheckInAt:        time.Now(),
+		CheckInLat:       *req.Lat,
+		CheckInLon:       *req.Lon,
+		CheckInDistanceM: distance,
+		LocationVerified: verified,
+	}
+	visitCreated := storage.DB.Create(&visit)
+	if visitCreated.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	notifyUser(booking.UserID, bookingPath(booking.ID), "Specialist arrived", "Your specialist checked in at "+visit.CheckInAt.Format(time.Kitchen)+".")
+	ctx.JSON(visit)
+}
+
+// CheckOut ends the specialist's open visit, under the same location check as
+// checking in. A check-out away from the address is still recorded, as
+// unverified, so the visit does not stay open; so is one made after the
+// booking was paused or the specialist stopped covering it.
+func CheckOut(ctx iris.Context) {
+	var req VisitInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	var visit models.Visit
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	visitExists := storage.DB.Where("booking_id = ? AND specialist_id = ? AND check_out_at IS NULL", req.BookingID, claims.ID).Limit(1).Find(&visit)
+	if visitExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if visitExists.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You are not checked in to this booking.", ctx)
+		return
+	}
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", visit.BookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	distance, verified, _ := visitDistance(&user, req)
+
+	now := time.Now()
+	visit.CheckOutAt = &now
+	visit.CheckOutLat = *req.Lat
+	visit.CheckOutLon = *req.Lon
+	visit.CheckOutDistanceM = distance
+	visit.LocationVerified = visit.LocationVerified && verified
+	visitClosed := storage.DB.Model(&visit).Where("check_out_at IS NULL").Updates(map[string]interface{}{
+		"check_out_at":         visit.CheckOutAt,
+		"check_out_lat":        visit.CheckOutLat,
+		"check_out_lon":        visit.CheckOutLon,
+		"check_out_distance_m": visit.CheckOutDistanceM,
+		"location_verified":    visit.LocationVerified,
+	})
+	if visitClosed.Error != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	if visitClosed.RowsAffected == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You are not checked in to this booking.", ctx)
+		return
+	}
+
+	notifyUser(booking.UserID, bookingPath(booking.ID), "Specialist left", "Your specialist checked out at "+now.Format(time.Kitchen)+".")
+	ctx.JSON(visit)
+}
+
+// GetTimesheet lists a booking's visits for a bill's period, or for a range
+// of dates, with the totals worked.
+func GetTimesheet(ctx iris.Context) {
+	var query TimesheetQuery
+	err := ctx.ReadQuery(&query)
+	if err != nil {
+		utils.ValidationError(err, ctx)
+		return
+	}
+
+	var booking *models.Booking
+	from, to := query.From, query.To
+	if query.BillID > 0 {
+		var bill *models.Bill
+		bill, booking, _ = getBillForViewer(query.BillID, ctx)
+		if bill == nil {
+			return
+		}
+		from, to = bill.PeriodStart, bill.PeriodEnd
+	} else {
+		booking, _ = getBookingForViewer(query.BookingID, ctx)
+		if booking == nil {
+			return
+		}
+	}
+
+	timesheet, err := buildTimesheet(booking, from, to)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+	ctx.JSON(timesheet)
+}
+
+// buildTimesheet gathers the visits checked in within [from, to). A zero
+// bound leaves that side open.
+func buildTimesheet(booking *models.Booking, from time.Time, to time.Time) (*Timesheet, error) {
+	query := storage.DB.Where("booking_id = ?", booking.ID)
+	if !from.IsZero() {
+		query = query.Where("check_in_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("check_in_at < ?", to)
+	}
+
+	timesheet := Timesheet{BookingID: booking.ID, From: from, To: to, Visits: []models.Visit{}}
+	visitsExist := query.Order("check_in_at").Find(&timesheet.Visits)
+	if visitsExist.Error != nil {
+		return nil, visitsExist.Error
+	}
+
+	// Days are counted in the booking's timezone, as its schedule is.
+	location, err := time.LoadLocation(booking.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	days := make(map[string]bool)
+	for _, visit := range timesheet.Visits {
+		days[visit.CheckInAt.In(location).Format(time.DateOnly)] = true
+		if visit.CheckOutAt == nil {
+			timesheet.OpenVisit = true
+			continue
+		}
+		timesheet.TotalMinutes += int(visit.CheckOutAt.Sub(visit.CheckInAt).Minutes())
+		if !visit.LocationVerified {
+			timesheet.UnverifiedVisits++
+		}
+	}
+	timesheet.DaysVisited = len(days)
+
+	// The visits the schedule called for over a closed range, less paused
+	// ones, make missed visits plain to see.
+	if !from.IsZero() && to.After(from) {
+		schedule, err := bookingOccurrenceSchedule(booking)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := acceptedBookingChanges(booking.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, occurrence := range schedule.Between(from, to) {
+			if !occurrencePaused(changes, occurrence) {
+				timesheet.VisitsScheduled++
+			}
+		}
+	}
+	return &timesheet, nil
+}
+
+// getBookingForVisit loads an active booking the calling specialist covers
+// now along with the user visited. That is the booking's specialist, or the
+// substitute approved while they are away.
+func getBookingForVisit(bookingID uint, ctx iris.Context) (*models.Booking, *models.User) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", bookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if bookingExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil
+	}
+
+	substitutions, err := bookingSubstitutions(booking.ID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if !claims.IsSpecialist() || specialistAt(&booking, substitutions, time.Now()) != claims.ID {
+		utils.CreateForbidden(ctx)
+		return nil, nil
+	}
+	if booking.Status != BookingActive {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Visits can only be recorded on an active booking.", ctx)
+		return nil, nil
+	}
+
+	var user models.User
+	userExists := storage.DB.Where("id = ?", booking.UserID).Find(&user)
+	if userExists.Error != nil {
+		utils.InternalServerError(ctx)
+		return nil, nil
+	}
+	if userExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil, nil
+	}
+	return &booking, &user
+}
+
+// visitDistance measures the device from the user's stored location in
+// meters and reports whether the location could be verified at all.
+func visitDistance(user *models.User, req VisitInput) (float64, bool, error) {
+	if user.Lat == 0 && user.Lon == 0 {
+		return 0, false, nil
+	}
+	distance := utils.DistanceKm(float64(user.Lat), float64(user.Lon), float64(*req.Lat), float64(*req.Lon)) * 1000
+	if distance > utils.EnvFloat("VISIT_TOLERANCE_METERS", 200) {
+		return distance, false, errOutsideVisitTolerance
+	}
+	return distance, true, nil
+}
+
+// disputeTimesheet is the timesheet for a disputed bill's period, which both
+// parties and the admin see alongside the dispute.
+func disputeTimesheet(dispute *models.BillDispute) (*Timesheet, error) {
+	var bill models.Bill
+	billExists := storage.DB.Where("id = ?", dispute.BillID).Find(&bill)
+	if billExists.Error != nil {
+		return nil, billExists.Error
+	}
+	var booking models.Booking
+	bookingExists := storage.DB.Where("id = ?", dispute.BookingID).Find(&booking)
+	if bookingExists.Error != nil {
+		return nil, bookingExists.Error
+	}
+	return buildTimesheet(&booking, bill.PeriodStart, bill.PeriodEnd)
+}
+
+type VisitInput struct {
+	BookingID uint     `json:"bookingID" validate:"required"`
+	Lat       *float32 `json:"lat" validate:"required,latitude"`
+	Lon       *float32 `json:"lon" validate:"required,longitude"`
+}
+
+type TimesheetQuery struct {
+	BookingID uint      `url:"bookingId" validate:"required_without=BillID"`
+	BillID    uint      `url:"billId"`
+	From      time.Time `url:"from"`
+	To        time.Time `url:"to"`
+}
+
+type Timesheet struct {
+	BookingID        uint           `json:"bookingID"`
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
+	Visits           []models.Visit `json:"visits"`
+	DaysVisited      int            `json:"daysVisited"`
+	VisitsScheduled  int            `json:"visitsScheduled"`
+	TotalMinutes     int            `json:"totalMinutes"`
+	UnverifiedVisits int            `json:"unverifiedVisits"`
+	OpenVisit        bool           `json:"openVisit"`
+}
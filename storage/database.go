@@ -2,6 +2,8 @@ package storage
 
 import (
 	"jotno-server/models"
+	"jotno-server/money"
+	"jotno-server/recurrence"
 	"log"
 	"os"
 
@@ -36,11 +38,142 @@ func performMigrations(db *gorm.DB) {
 		&models.Review{},
 		&models.JobPost{},
 		&models.Comment{},
+		&models.JobApplication{},
+		&models.JobPostDismissal{},
+		&models.SavedSearch{},
+		&models.JobAlert{},
+		&models.Notification{},
 		&models.Chat{},
 		&models.Message{},
 		&models.Booking{},
+		&models.BookingHistory{},
+		&models.BookingChangeRequest{},
+		&models.Visit{},
 		&models.Bill{},
+		&models.PaymentAttempt{},
+		&models.BillDispute{},
+		&models.DisputeEvidence{},
+		&models.DisputeMessage{},
+		&models.Refund{},
+		&models.CommissionRule{},
+		&models.LedgerTransaction{},
+		&models.LedgerEntry{},
+		&models.ReminderLog{},
+		&models.Document{},
+		&models.JobRun{},
+		&models.CalendarFeed{},
+		&models.Substitution{},
 	)
+	performDataMigrations(db)
+}
+
+// performDataMigrations backfills columns added after rows already existed.
+// Every statement must be safe to run on each start.
+func performDataMigrations(db *gorm.DB) {
+	statements := []string{
+		"UPDATE job_posts SET status = 'open' WHERE status IS NULL OR status = ''",
+		// Posts from before expiry get a full 30 days from now, rather than
+		// all expiring, with a push each, on the next run.
+		"UPDATE job_posts SET expires_at = GREATEST(created_at, now()) + INTERVAL '30 days' WHERE expires_at IS NULL",
+		"UPDATE bookings SET status = 'active' WHERE active = true AND status = 'pending'",
+		"UPDATE bookings SET status = 'requested' WHERE status = 'pending'",
+		"UPDATE bookings SET requested_at = created_at WHERE requested_at IS NULL",
+		"CREATE SEQUENCE IF NOT EXISTS invoice_number_seq",
+		`UPDATE bills SET dispute_status = bill_disputes.status FROM bill_disputes
+		WHERE bill_disputes.bill_id = bills.id AND (bills.dispute_status IS NULL OR bills.dispute_status = '')`,
+		"UPDATE bookings SET recurrence = 'FREQ=DAILY' WHERE recurrence IS NULL OR recurrence = ''",
+		"UPDATE bills SET kind = 'period' WHERE kind IS NULL OR kind = ''",
+		`UPDATE bills SET specialist_id = bookings.specialist_id FROM bookings
+		WHERE bookings.id = bills.booking_id AND (bills.specialist_id IS NULL OR bills.specialist_id = 0)`,
+		"DROP INDEX IF EXISTS idx_bill_booking_period",
+		"UPDATE jobs SET cancellation_policy = 'flexible' WHERE cancellation_policy IS NULL OR cancellation_policy = ''",
+		"UPDATE bookings SET start_time = '00:00', end_time = '00:00' WHERE start_time IS NULL OR start_time = ''",
+		"DROP INDEX IF EXISTS idx_message_chat_created",
+		`UPDATE messages SET sender_role = CASE
+			WHEN chats.specialist_id = messages.sender_id AND chats.user_id <> messages.sender_id THEN 'specialist'
+			ELSE 'user' END
+		FROM chats WHERE chats.id = messages.chat_id AND (messages.sender_role IS NULL OR messages.sender_role = '')`,
+		`UPDATE bookings SET cancellation_policy = jobs.cancellation_policy FROM jobs
+		WHERE jobs.specialist_id = bookings.specialist_id AND jobs.job_name = bookings.job_type
+		AND bookings.status <> 'requested' AND (bookings.cancellation_policy IS NULL OR bookings.cancellation_policy = '')`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Println("data migration failed:", statement, err)
+		}
+	}
+	timezoneSet := db.Exec("UPDATE bookings SET timezone = ? WHERE timezone IS NULL OR timezone = ''", recurrence.DefaultTimezone())
+	if timezoneSet.Error != nil {
+		log.Println("data migration failed: booking timezones", timezoneSet.Error)
+	}
+	migrateMoneyColumns(db)
+}
+
+// moneyColumn is an amount that used to be stored in major units, with the
+// SQL giving its currency.
+type moneyColumn struct {
+	table    string
+	column   string
+	currency string
+}
+
+// Amounts older than money.Money, in the order they are migrated. Bill
+// disputes read their currency from the bill, so bills keep their currency
+// column until every amount has moved.
+var moneyColumns = []moneyColumn{
+	{"bills", "amount", "bills.currency"},
+	{"bills", "platform_fee", "bills.currency"},
+	{"bills", "refunded_amount", "bills.currency"},
+	{"bill_disputes", "refund_amount", "(SELECT bills.currency FROM bills WHERE bills.id = bill_disputes.bill_id)"},
+	{"bookings", "amount", "bookings.currency"},
+	{"job_posts", "wage", "job_posts.wage_currency"},
+	{"job_applications", "rate", "job_applications.currency"},
+	{"saved_searches", "min_wage", "'BDT'"},
+	{"payment_attempts", "amount", "payment_attempts.currency"},
+	{"refunds", "amount", "refunds.currency"},
+	{"ledger_entries", "amount", "ledger_entries.currency"},
+}
+
+var legacyCurrencyTables = []string{"bills", "bookings", "job_applications", "payment_attempts", "refunds", "ledger_entries"}
+
+// migrateMoneyColumns converts each old amount column into the <column>_minor
+// and <column>_currency pair and drops it. A column that is already gone has
+// been migrated. Everything before money.Money was billed in BDT, which is
+// assumed where no currency was recorded.
+func migrateMoneyColumns(db *gorm.DB) {
+	migrated := true
+	for _, c := range moneyColumns {
+		if !db.Migrator().HasColumn(c.table, c.column) {
+			continue
+		}
+		currency := "UPPER(COALESCE(NULLIF(" + c.currency + ", ''), 'BDT'))"
+		err := db.Transaction(func(tx *gorm.DB) error {
+			converted := tx.Exec("UPDATE " + c.table + " SET " +
+				c.column + "_minor = COALESCE(" + c.column + ", 0)::bigint * " + money.MinorFactorSQL(currency) + ", " +
+				c.column + "_currency = " + currency +
+				" WHERE " + c.column + "_minor IS NULL")
+			if converted.Error != nil {
+				return converted.Error
+			}
+			return tx.Migrator().DropColumn(c.table, c.column)
+		})
+		if err != nil {
+			log.Println("money migration failed:", c.table, c.column, err)
+			migrated = false
+		}
+	}
+	if !migrated {
+		return
+	}
+
+	for _, table := range legacyCurrencyTables {
+		if !db.Migrator().HasColumn(table, "currency") {
+			continue
+		}
+		if err := db.Migrator().DropColumn(table, "currency"); err != nil {
+			log.Println("money migration failed:", table, "currency", err)
+		}
+	}
 }
 
 func InitializeDB() *gorm.DB {
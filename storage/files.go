@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// UploadFile stores a private file in the bucket under key.
+func UploadFile(key string, data []byte, contentType string) error {
+	uploader := manager.NewUploader(S3Client)
+	_, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket:      &BucketName,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+	})
+	return err
+}
+
+func DownloadFile(key string) ([]byte, error) {
+	object, err := S3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: &BucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+	return io.ReadAll(object.Body)
+}
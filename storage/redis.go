@@ -1,6 +1,11 @@
 package storage
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
 	"github.com/redis/go-redis/v9"
 )
 
@@ -13,3 +18,46 @@ func InitializeRedis() {
 		DB:       0,
 	})
 }
+
+// releaseLeaseScript deletes a lease only while it still belongs to the caller,
+// so a lease that expired and was taken over is never released by mistake.
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// renewLeaseScript extends a lease only while it still belongs to the caller.
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// AcquireLease takes an exclusive, expiring lease on key. It returns the token
+// needed to release it, or an empty token when someone else holds the lease.
+func AcquireLease(key string, ttl time.Duration) (string, error) {
+	tokenBytes := make([]byte, 16)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	acquired, err := Redis.SetNX(context.Background(), key, token, ttl).Result()
+	if err != nil || !acquired {
+		return "", err
+	}
+	return token, nil
+}
+
+func ReleaseLease(key string, token string) error {
+	return releaseLeaseScript.Run(context.Background(), Redis, []string{key}, token).Err()
+}
+
+// RenewLease resets a held lease's expiry to ttl. It reports false when the
+// lease has already expired or been taken over.
+func RenewLease(key string, token string, ttl time.Duration) (bool, error) {
+	renewed, err := renewLeaseScript.Run(context.Background(), Redis, []string{key}, token, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
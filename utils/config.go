@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvInt reads an integer setting from the environment, falling back when it
+// is unset or malformed.
+func EnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// EnvFloat reads a decimal setting from the environment, falling back when it
+// is unset or malformed.
+func EnvFloat(name string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// EnvIntList reads a comma-separated list of integers from the environment,
+// falling back when it is unset or any entry is malformed.
+func EnvIntList(name string, fallback []int) []int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	var values []int
+	for _, part := range strings.Split(raw, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "math"
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm returns the great-circle distance between two coordinates.
+func DistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// DistanceSQL returns a Postgres expression for the distance in km between the
+// given lat/lon columns and a point bound through three placeholders (lat, lon, lat).
+func DistanceSQL(latColumn string, lonColumn string) string {
+	return "(6371 * acos(LEAST(1, GREATEST(-1, " +
+		"cos(radians(?)) * cos(radians(" + latColumn + ")) * cos(radians(" + lonColumn + ") - radians(?)) + " +
+		"sin(radians(?)) * sin(radians(" + latColumn + "))))))"
+}
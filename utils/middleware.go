@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/subtle"
+	"os"
 	"strconv"
 
 	"github.com/kataras/iris/v12"
@@ -19,3 +21,35 @@ func UserIDMiddleware(ctx iris.Context) {
 	}
 	ctx.Next()
 }
+
+func SpecialistMiddleware(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*AccessToken)
+
+	if !claims.IsSpecialist() {
+		CreateForbidden(ctx)
+		return
+	}
+	ctx.Next()
+}
+
+func UserMiddleware(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*AccessToken)
+
+	if claims.IsSpecialist() {
+		CreateForbidden(ctx)
+		return
+	}
+	ctx.Next()
+}
+
+// AdminMiddleware guards operator endpoints with the shared ADMIN_API_KEY.
+func AdminMiddleware(ctx iris.Context) {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	providedKey := ctx.GetHeader("X-Admin-Key")
+
+	if adminKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(providedKey)) != 1 {
+		CreateForbidden(ctx)
+		return
+	}
+	ctx.Next()
+}
@@ -13,6 +13,12 @@ import (
 
 var bgContext = context.Background()
 
+const (
+	RoleUser       = "user"
+	RoleSpecialist = "specialist"
+	RoleAdmin      = "admin"
+)
+
 func CreateForgotPasswordToken(id uint, email string) (string, error) {
 	signer := jwt.NewSigner(jwt.HS256, os.Getenv("EMAIL_TOKEN_SECRET"), 10*time.Minute)
 	claims := ForgotPasswordToken{
@@ -26,16 +32,17 @@ func CreateForgotPasswordToken(id uint, email string) (string, error) {
 	return string(token), nil
 }
 
-func CreateTokenPair(id uint) (*jwt.TokenPair, error) {
+func CreateTokenPair(id uint, role string) (*jwt.TokenPair, error) {
 	accessTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("ACCESS_TOKEN_SECRET"), 24*time.Hour)
 	refreshTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("REFRESH_TOKEN_SECRET"), 365*24*time.Hour)
 
 	userID := strconv.FormatUint(uint64(id), 10)
 
-	refreshClaims := jwt.Claims{Subject: userID}
+	refreshClaims := jwt.Claims{Subject: userID, Audience: []string{role}}
 
 	accessTokenClaims := AccessToken{
-		ID: id,
+		ID:   id,
+		Role: role,
 	}
 
 	accessToken, err := accessTokenSigner.Sign(accessTokenClaims)
@@ -79,7 +86,12 @@ func RefreshToken(ctx iris.Context) {
 		return
 	}
 
-	tokenPair, tokenPairErr := CreateTokenPair(uint(userID))
+	role := RoleUser
+	if len(token.StandardClaims.Audience) > 0 {
+		role = token.StandardClaims.Audience[0]
+	}
+
+	tokenPair, tokenPairErr := CreateTokenPair(uint(userID), role)
 	if tokenPairErr != nil {
 		InternalServerError(ctx)
 		return
@@ -97,7 +109,13 @@ type ForgotPasswordToken struct {
 }
 
 type AccessToken struct {
-	ID uint `json:"ID"`
+	ID   uint   `json:"ID"`
+	Role string `json:"role"`
+}
+
+// Tokens issued before roles were introduced carry no role and belong to users.
+func (token *AccessToken) IsSpecialist() bool {
+	return token.Role == RoleSpecialist
 }
 
 type RefreshTokenInput struct {